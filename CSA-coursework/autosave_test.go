@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+)
+
+// TestAutosave tests that checkpoints are written in the background every so many turns or so much time,
+// that only the newest are kept, and that a run can be resumed from them.
+func TestAutosave(t *testing.T) {
+	t.Run("turns", func(t *testing.T) {
+		p := gol.Params{ImageWidth: 64, ImageHeight: 64, Turns: 100, Threads: 2, OutputDir: t.TempDir(),
+			AutosaveTurns: 10, AutosaveKeep: 3}
+		saves := runAutosaves(p)
+		if len(saves) == 0 {
+			t.Fatal("ERROR: expected ImageOutputComplete events for autosaves, got none")
+		}
+		// an autosave due while the last is still being written is retried on the next turn
+		previous := 0
+		for _, save := range saves {
+			if save.CompletedTurns <= previous || save.CompletedTurns < 10 || save.CompletedTurns >= 100 {
+				t.Errorf("ERROR: expected autosaves from turn 10 until before the end, got one after %d following %d", save.CompletedTurns, previous)
+			}
+			previous = save.CompletedTurns
+		}
+
+		files, err := ioutil.ReadDir(p.OutputDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var kept []string
+		for _, file := range files {
+			if strings.HasSuffix(file.Name(), "-autosave.golc") {
+				kept = append(kept, file.Name())
+			}
+		}
+		if len(kept) > 3 || len(kept) != len(saves) && len(kept) != 3 {
+			t.Errorf("ERROR: expected the newest 3 of %d autosaves to be kept, got %v", len(saves), kept)
+		}
+
+		// the newest autosave carries on to the same final world
+		last := saves[len(saves)-1]
+		resumed := gol.Params{Turns: 100, Threads: 2, OutputDir: t.TempDir(), Resume: last.Path}
+		alive := runFinal(resumed)
+		expectedAlive := readAliveCells("check/images/64x64x100.pgm", 64, 64)
+		assertEqualBoard(t, alive, expectedAlive, gol.Params{ImageWidth: 64, ImageHeight: 64, Turns: 100})
+	})
+
+	t.Run("interval", func(t *testing.T) {
+		p := gol.Params{ImageWidth: 64, ImageHeight: 64, Turns: 2000, Threads: 2, OutputDir: t.TempDir(),
+			AutosaveInterval: time.Millisecond, AutosaveKeep: 1}
+		saves := runAutosaves(p)
+		if len(saves) == 0 {
+			t.Fatal("ERROR: expected ImageOutputComplete events for autosaves, got none")
+		}
+		kept, err := filepath.Glob(filepath.Join(p.OutputDir, "*.golc"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(kept) != 1 || kept[0] != saves[len(saves)-1].Path {
+			t.Errorf("ERROR: expected only the newest autosave %v to be kept, got %v", saves[len(saves)-1].Path, kept)
+		}
+	})
+
+	for _, every := range []string{"0", "-5", "soon", "-1s"} {
+		if _, _, err := gol.ParseAutosave(every); err == nil {
+			t.Errorf("ERROR: expected an error for autosave interval %q", every)
+		}
+	}
+	if turns, interval, err := gol.ParseAutosave("250"); err != nil || turns != 250 || interval != 0 {
+		t.Errorf("ERROR: expected 250 turns, got %d, %v, %v", turns, interval, err)
+	}
+	if turns, interval, err := gol.ParseAutosave("1m30s"); err != nil || turns != 0 || interval != 90*time.Second {
+		t.Errorf("ERROR: expected 90s, got %d, %v, %v", turns, interval, err)
+	}
+}
+
+// runAutosaves runs the Game of Life to the end and returns the ImageOutputComplete events of its autosaves.
+func runAutosaves(p gol.Params) []gol.ImageOutputComplete {
+	events := make(chan gol.Event)
+	go gol.Run(p, events, nil)
+	var saves []gol.ImageOutputComplete
+	for event := range events {
+		if e, ok := event.(gol.ImageOutputComplete); ok && strings.HasSuffix(e.Filename, "-autosave") {
+			saves = append(saves, e)
+		}
+	}
+	return saves
+}
@@ -2,33 +2,116 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"math"
 	"net"
 	"net/rpc"
 	"os"
 	"sync"
+	"time"
 	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/gol/engine"
 
 	"uk.ac.bris.cs/gameoflife/util"
 )
 
 var mutex sync.Mutex
 
+// turnMutex is held while a turn is stepped, so the world is only pulled from the workers between turns
+var turnMutex sync.Mutex
+
 type Broker struct {
 	Pause              bool
 	Resume             chan bool
 	Turn               int
 	CellCount          int
-	Clients            []*rpc.Client
+	Workers            []*Worker        // Registered workers, in the order they joined
+	Lost               []gol.WorkerLost // Workers lost since the controller last asked
 	CombinedWorld      [][]byte
 	CombinedAliveCells []util.Cell
+	Autosaves          []autosave    // Copies of the world queued for the controller to write as autosaves
+	Session            string        // ID of the latest session, which carries on if its controller disconnects
+	Done               chan struct{} // Closed when the session has run its last turn
+	Result             *gol.Response // The final state of the session, once it is done
+	detached           bool          // The controller left the session running with 'q'
+	polled             time.Time     // When a controller last followed the session
+	stopping           bool          // The session stops at the next turn, for a new one to replace it
+	pausing            string        // A session paused before the broker started it
+	changes            []gol.Change  // Cells changed on each turn since the controller last followed the session
+	changed            int           // The last turn whose changes have been queued
+	queued             int           // Number of cells in changes
+	params             gol.Params
+	packed             bool             // Strips are sent to and from workers packed, see gol.PackRows
+	world              [][]byte         // The world while the broker keeps it, nil while workers keep it
+	life               *engine.HashLife // The world while the hashlife engine advances it
+	layout             []strip          // Strips kept by workers between turns, nil while the broker keeps the world
+	batcher            batcher
 	//LoadPredictor      *LoadPredictor
 }
 
-var addresses = []string{
-	"127.0.0.1:8040",
-	"127.0.0.1:8050",
-	"127.0.0.1:8060",
-	"127.0.0.1:8070",
+// controllerTimeout is how long a session goes without a controller following it before it is taken to be
+// abandoned, as when the controller was killed. Abandoned and detached sessions are replaced by the next one started.
+const controllerTimeout = 2 * time.Second
+
+// changesLimit is the most changed cells queued for the controller to follow the session turn by turn.
+// Past it the queue is dropped, and the controller catches up with the whole world instead.
+const changesLimit = 1 << 20
+
+// retainInterval is how often the broker pulls the world from the workers to retain it.
+// When a worker is lost, the turns since the retained world are replayed by the others.
+const retainInterval = time.Second
+
+// Worker is a GOL worker registered with the broker.
+type Worker struct {
+	Address  string
+	Capacity int
+	Client   *rpc.Client
+	LastSeen time.Time // When the worker last registered or sent a heartbeat
+}
+
+// batcher picks how many turns the workers step their strips by between round trips, from the measured latency
+// of a round trip and the time a worker takes to step a row. A batch of k turns steps a strip of n rows padded by
+// k halos of radius rows on each side, one halo fewer each turn, so a turn costs latency/k + row*(n+(k-1)*radius)
+// on average, which is least at k = sqrt(latency/(row*radius)). The strip height bounds k, as halos deeper than
+// the strip would mean stepping more redundant rows than rows of the strip.
+type batcher struct {
+	latency time.Duration // Time of a round trip not spent stepping, averaged over the recent ones
+	row     time.Duration // Time to step a row for a turn, averaged over the recent round trips
+}
+
+// measure adds a round trip that took roundTrip, of which the slowest worker spent stepping a total of rows rows.
+func (bt *batcher) measure(roundTrip, stepping time.Duration, rows int) {
+	latency := roundTrip - stepping
+	if latency < 0 {
+		latency = 0
+	}
+	row := stepping / time.Duration(rows)
+	if bt.row == 0 {
+		bt.latency, bt.row = latency, row
+		return
+	}
+	bt.latency, bt.row = (bt.latency+latency)/2, (bt.row+row)/2
+}
+
+// size returns the number of turns of the next batch, between 1 and limit.
+func (bt *batcher) size(radius, limit int) int {
+	k := 1
+	if bt.row > 0 {
+		k = int(math.Sqrt(float64(bt.latency) / float64(bt.row*time.Duration(radius))))
+	}
+	if k > limit {
+		k = limit
+	}
+	if k < 1 {
+		k = 1
+	}
+	return k
+}
+
+// strip is the rows [start, end) of the world kept by a worker, or by the broker itself if worker is nil.
+type strip struct {
+	start, end int
+	worker     *Worker
 }
 
 //type LoadPredictor struct {
@@ -53,97 +136,171 @@ var addresses = []string{
 //}
 
 func (b *Broker) GolInitializer(req gol.Request, res *gol.Response) error {
+	rule, err := engine.ParseRule(req.Parameter.Rule)
+	if err != nil {
+		return err
+	}
+	topology, err := engine.ParseTopology(req.Parameter.Topology)
+	if err != nil {
+		return err
+	}
+	// Segments carry their own padding, so they are stepped as planes
+	stepper, err := engine.New(req.Parameter.Engine, rule, engine.Plane, 1)
+	if err != nil {
+		return err
+	}
+
+	// A session carries on without its controller, so another one can't start while a controller follows it.
+	// One nobody follows any more is stopped for the new one. A controller that is leaving it is given
+	// up to controllerTimeout to do so.
+	mutex.Lock()
+	asked := time.Now()
+	for b.Done != nil && b.Result == nil {
+		if !b.detached && time.Since(b.polled) < controllerTimeout {
+			if time.Since(asked) >= controllerTimeout {
+				mutex.Unlock()
+				return fmt.Errorf("session %v is still running, attach to it instead", b.Session)
+			}
+			mutex.Unlock()
+			time.Sleep(50 * time.Millisecond)
+			mutex.Lock()
+			continue
+		}
+		b.stopping, b.Pause = true, false
+		done, resume := b.Done, b.Resume
+		mutex.Unlock()
+		go func() {
+			// a paused session is woken up to stop
+			select {
+			case resume <- true:
+			case <-done:
+			}
+		}()
+		<-done
+		mutex.Lock()
+	}
+	b.Session, b.Done, b.Result = req.Session, make(chan struct{}), nil
+	b.detached, b.polled, b.stopping = false, time.Now(), false
+	// Its controller may have pressed p before the session started
+	b.Turn, b.CellCount, b.Pause = req.Parameter.StartTurn, 0, b.pausing != "" && b.pausing == req.Session
+	b.pausing = ""
+	b.changes, b.changed, b.queued = nil, req.Parameter.StartTurn, 0
+	b.Resume = make(chan bool)
+	mutex.Unlock()
 
 	// Initialize the game world and distribute tasks to GOL workers
 	world := copySlice(req.World)
-	b.Resume = make(chan bool)
+	turnMutex.Lock()
+	b.params, b.packed, b.world, b.layout, b.life = req.Parameter, rule.Packable(), world, nil, nil
+	turnMutex.Unlock()
 	b.CombinedWorld = make([][]byte, req.Parameter.ImageHeight)
 	for i := range b.CombinedWorld {
 		b.CombinedWorld[i] = make([]byte, req.Parameter.ImageWidth)
 	}
+	// Autosaves are only copied here, the controller collects and writes them so turns never wait on the disk
+	b.Autosaves = nil
+	lastAutosave := time.Now()
 	// Synchronization
-	if req.Parameter.Turns == 0 {
-		if b.Pause {
-			<-b.Resume
+	if req.Parameter.Engine == "hashlife" {
+		// The hashlife quadtree cannot be split into strips, so the broker advances it itself
+		life, err := engine.NewHashLife(world, rule, topology)
+		if err != nil {
+			b.finish(res)
+			return err
 		}
+		turnMutex.Lock()
+		b.life = life
+		turnMutex.Unlock()
+		turn := req.Parameter.StartTurn
+		for turn < req.Parameter.Turns && !b.stopped() {
+			b.waitResumed()
+			// Jumps stop at autosaves, which would otherwise be jumped over
+			jump := req.Parameter.Turns - turn
+			if every := req.Parameter.AutosaveTurns; every > 0 && every-turn%every < jump {
+				jump = every - turn%every
+			}
+			turnMutex.Lock()
+			turn += life.Advance(jump)
+			mutex.Lock()
+			b.Turn = turn
+			b.CellCount = life.Count()
+			// a jump has no changes turn by turn, so the controller catches up with the whole world
+			b.changes, b.changed, b.queued = nil, turn, 0
+			mutex.Unlock()
+			turnMutex.Unlock()
+			if turn < req.Parameter.Turns && autosaveDue(req.Parameter, turn, &lastAutosave) {
+				b.keepAutosave(life.Grid().Unpack(), turn)
+			}
+		}
+		b.CombinedWorld = life.Grid().Unpack()
+		res.Turns = turn
+		turnMutex.Lock()
+		b.world, b.life = copySlice(b.CombinedWorld), nil
+		turnMutex.Unlock()
+	} else if req.Parameter.Turns == 0 {
+		b.waitResumed()
 		b.CombinedWorld = copySlice(req.World)
+		res.Turns = req.Parameter.Turns
 
 	} else {
-		//define a slicePool to reuse [][]byte slices
-		var slicePool = sync.Pool{
-			New: func() interface{} {
-				return make([][]byte, 0)
-			},
-		}
+		// Workers keep their strips between turns and fetch halo rows from each other, so the broker only
+		// starts each turn and pulls the world back when it is asked for, or to retain it
+		var region *engine.ActiveRegion
+		b.batcher = batcher{}
+		retained, retainedTurn, lastRetained := copySlice(world), req.Parameter.StartTurn, time.Now()
+		for i := req.Parameter.StartTurn; i < req.Parameter.Turns && !b.stopped(); {
+			b.waitResumed()
 
-		for i := 0; i < req.Parameter.Turns; i++ {
+			turnMutex.Lock()
+			// Strips are rebalanced whenever workers join or leave
 			mutex.Lock()
-			if b.Pause {
-				mutex.Unlock()
-				<-b.Resume
-			} else {
-				mutex.Unlock()
+			workers := append([]*Worker(nil), b.Workers...)
+			mutex.Unlock()
+			err := b.balance(workers, i, rule.Radius)
+			batch := 1
+			if err == nil && b.layout == nil {
+				// With the active engine, a world with no tile near last turn's changes is left as it is.
+				// Tiles are only tracked while the broker steps the world itself
+				if region == nil && req.Parameter.Engine == "active" {
+					region = engine.NewActiveRegion(req.Parameter.ImageWidth, req.Parameter.ImageHeight, engine.DefaultTileSize, rule, topology)
+				}
+				b.stepLocal(stepper, topology, rule.Radius, region, i)
+			} else if err == nil {
+				region = nil
+				batch = b.batcher.size(rule.Radius, b.batchLimit(i, rule.Radius))
+				err = b.stepStrips(i, batch, rule.Radius)
 			}
-
-			var wg sync.WaitGroup
-			nodes := len(b.Clients)
-			responses := make([][][]byte, len(b.Clients))
-			for i, client := range b.Clients {
-				wg.Add(1)
-				go func(i int, client *rpc.Client) {
-					defer wg.Done()
-					haloSize := req.Parameter.ImageHeight / nodes
-					start := i * haloSize
-					end := (i + 1) * haloSize
-					if i == nodes-1 {
-						end = req.Parameter.ImageHeight
-					}
-					//newReq := req
-					segment := slicePool.Get().([][]byte)
-					//create a segment with haloSize+2 rows
-					segment = segment[:haloSize+2]
-					for k := range segment {
-						segment[k] = make([]byte, req.Parameter.ImageWidth)
-					}
-					//copy the world to the segment
-					for j := 0; j < haloSize; j++ {
-						copy(segment[j+1], world[start+j])
-					}
-
-					if i == 0 {
-						copy(segment[0], world[req.Parameter.ImageHeight-1])
-					} else {
-						copy(segment[0], world[start-1])
-					}
-					//copy the halo rows to the segment
-					if i == nodes-1 {
-						copy(segment[haloSize+1], world[0])
-					} else {
-						copy(segment[haloSize+1], world[end])
+			if err == nil {
+				i += batch
+				mutex.Lock()
+				b.Turn = i
+				mutex.Unlock()
+				due := i < req.Parameter.Turns && autosaveDue(req.Parameter, i, &lastAutosave)
+				if due || i == req.Parameter.Turns || time.Since(lastRetained) >= retainInterval {
+					var current [][]byte
+					if current, err = b.currentWorld(i); err == nil {
+						retained, retainedTurn, lastRetained = current, i, time.Now()
+						if due {
+							b.keepAutosave(copySlice(current), i)
+						}
 					}
-					// //process the segment
-					// processedSegment := processSegment(segment, req.Parameter)
-
-					// for j := 0; j < haloSize; j++ {
-					// 	mutex.Lock()
-					// 	world[start+j] = processedSegment[j]
-					// 	mutex.Unlock()
-					// }
-
-					processedSegment := processSegment(segment, req.Parameter)
-					responses[i] = processedSegment
-				}(i, client)
-
-			}
-			wg.Wait()
-			// Combine results
-			for i, response := range responses {
-				start := i * (req.Parameter.ImageHeight / nodes)
-				for j := range response {
-					copy(world[start+j], response[j])
 				}
 			}
+			if err != nil {
+				// The workers that failed have been lost, so the others replay the turns since the retained world
+				b.world, b.layout, region = copySlice(retained), nil, nil
+				i = retainedTurn
+				mutex.Lock()
+				b.Turn = retainedTurn
+				mutex.Unlock()
+			}
+			turnMutex.Unlock()
 		}
+		turnMutex.Lock()
+		b.world, b.layout = retained, nil
+		turnMutex.Unlock()
+		b.CombinedWorld = retained
+		res.Turns = retainedTurn
 
 	}
 
@@ -163,53 +320,632 @@ func (b *Broker) GolInitializer(req gol.Request, res *gol.Response) error {
 	// Finalize
 	res.World = copySlice(b.CombinedWorld)
 	res.AliveCells = calculateAliveCells(req.Parameter, b.CombinedWorld)
-	res.End = true
+	// A session stopped for another one ends early
+	res.End = res.Turns == req.Parameter.Turns
+	b.finish(res)
+	return nil
+}
+
+// waitResumed blocks while the broker is paused, until the controller resumes it.
+func (b *Broker) waitResumed() {
+	mutex.Lock()
+	paused, resume := b.Pause, b.Resume
+	mutex.Unlock()
+	if paused {
+		<-resume
+	}
+}
+
+// stopped reports whether the session is to stop for a new one.
+func (b *Broker) stopped() bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return b.stopping
+}
+
+// finish keeps the final state of the session for controllers waiting on it, and lets another session start.
+func (b *Broker) finish(res *gol.Response) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	result := *res
+	b.Result = &result
+	close(b.Done)
+}
+
+// Attach answers the params of a session and the turn it has reached, for a controller taking it over.
+func (b *Broker) Attach(req gol.Request, res *gol.Response) error {
+	if err := b.checkSession(req.Session); err != nil {
+		return err
+	}
+	turnMutex.Lock()
+	res.Parameter = b.params
+	turnMutex.Unlock()
+	mutex.Lock()
+	res.Turns = b.Turn
+	b.detached, b.polled = false, time.Now()
+	mutex.Unlock()
 	return nil
+}
 
+// Detach records that the controller of a session quit with 'q', leaving it running.
+// Until another controller attaches, a new session replaces it.
+func (b *Broker) Detach(req gol.Request, res *gol.Response) error {
+	if err := b.checkSession(req.Session); err != nil {
+		return err
+	}
+	mutex.Lock()
+	b.detached = true
+	mutex.Unlock()
 	return nil
 }
 
-func processSegment(segment [][]byte, params gol.Params) [][]byte {
-	processed := make([][]byte, len(segment)-2)
-	for i := range processed {
-		processed[i] = make([]byte, len(segment[i+1]))
-		for j := range processed[i] {
-			// Implement efficient Game of Life logic here
-			// Example: only update if the state changes
-			processed[i][j] = segment[i+1][j] // Placeholder logic
+// Wait answers the final state of a session once it has run its last turn, as GolInitializer does.
+func (b *Broker) Wait(req gol.Request, res *gol.Response) error {
+	if err := b.checkSession(req.Session); err != nil {
+		return err
+	}
+	mutex.Lock()
+	done := b.Done
+	mutex.Unlock()
+	<-done
+	mutex.Lock()
+	defer mutex.Unlock()
+	*res = *b.Result
+	return nil
+}
+
+// GetLive answers how the session went on after turn req.Turn, which the controller has followed it to.
+// That is the cells changed on each turn since, or the whole world after the latest turn if they aren't all queued,
+// as after a hashlife jump or when the controller fell too far behind.
+// Only the controller of the session req.Session is answered, as the session it replaces may still be stopping.
+func (b *Broker) GetLive(req gol.Request, res *gol.Response) error {
+	mutex.Lock()
+	if req.Session != b.Session {
+		mutex.Unlock()
+		return fmt.Errorf("no session %v", req.Session)
+	}
+	b.polled = time.Now()
+	b.dropChanges(req.Turn)
+	res.Turns = b.Turn
+	res.CellCount = b.CellCount
+	if b.changed <= req.Turn || b.Turn <= req.Turn || len(b.changes) > 0 && b.changes[0].Turn == req.Turn+1 {
+		res.Changes = append([]gol.Change(nil), b.changes...)
+		mutex.Unlock()
+		return nil
+	}
+	mutex.Unlock()
+
+	turnMutex.Lock()
+	defer turnMutex.Unlock()
+	mutex.Lock()
+	turn, session := b.Turn, b.Session
+	mutex.Unlock()
+	if session != req.Session {
+		return fmt.Errorf("no session %v", req.Session)
+	}
+	world, err := b.currentWorld(turn)
+	if err != nil {
+		return err
+	}
+	res.World, res.Turns = world, turn
+	mutex.Lock()
+	b.dropChanges(turn)
+	mutex.Unlock()
+	return nil
+}
+
+// record queues the cells changed on each turn for the controller. Turns replayed after a worker was lost
+// are already queued. The mutex must be held.
+func (b *Broker) record(changes []gol.Change) {
+	for _, change := range changes {
+		if change.Turn <= b.changed {
+			continue
 		}
+		b.changes = append(b.changes, change)
+		b.changed = change.Turn
+		b.queued += len(change.Cells)
+	}
+	if b.queued > changesLimit {
+		b.changes, b.queued = nil, 0
+	}
+}
+
+// dropChanges drops the changes the controller has followed, up to and including turn. The mutex must be held.
+func (b *Broker) dropChanges(turn int) {
+	followed := 0
+	for followed < len(b.changes) && b.changes[followed].Turn <= turn {
+		b.queued -= len(b.changes[followed].Cells)
+		followed++
+	}
+	b.changes = b.changes[followed:]
+}
+
+// checkSession returns an error unless id is the latest session.
+func (b *Broker) checkSession(id string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if b.Done == nil || id != b.Session {
+		return fmt.Errorf("no session %v", id)
+	}
+	return nil
+}
+
+// Register adds a worker, which is given rows from the next turn on.
+// A worker registering again, e.g. after a restart, replaces its old registration.
+func (b *Broker) Register(req gol.Registration, res *gol.Response) error {
+	client, err := rpc.Dial("tcp", req.Address)
+	if err != nil {
+		return err
+	}
+	if req.Capacity < 1 {
+		req.Capacity = 1
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	b.removeWorker(req.Address)
+	b.Workers = append(b.Workers, &Worker{Address: req.Address, Capacity: req.Capacity, Client: client, LastSeen: time.Now()})
+	return nil
+}
+
+// Heartbeat records that a worker is still alive. A worker the broker doesn't know is told so, and registers again.
+func (b *Broker) Heartbeat(req gol.Registration, res *gol.Response) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, w := range b.Workers {
+		if w.Address == req.Address {
+			w.LastSeen = time.Now()
+			return nil
+		}
+	}
+	return fmt.Errorf("worker %v is not registered", req.Address)
+}
+
+// monitor removes workers that have missed three heartbeats in a row, until the broker exits.
+func (b *Broker) monitor() {
+	for range time.Tick(gol.HeartbeatInterval) {
+		mutex.Lock()
+		for _, w := range b.Workers {
+			if silent := time.Since(w.LastSeen); silent > 3*gol.HeartbeatInterval {
+				b.lose(w, b.Turn, fmt.Errorf("no heartbeat for %v", silent.Round(time.Second)))
+			}
+		}
+		mutex.Unlock()
+	}
+}
+
+// registered reports whether w is still a registered worker.
+func (b *Broker) registered(w *Worker) bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, registered := range b.Workers {
+		if registered == w {
+			return true
+		}
+	}
+	return false
+}
+
+// lose removes a worker that failed, keeping a WorkerLost event for the controller.
+// A worker already removed, e.g. by a failed call after missing its heartbeats, is only reported once.
+// The mutex must be held.
+func (b *Broker) lose(w *Worker, turn int, reason error) {
+	for _, registered := range b.Workers {
+		if registered == w {
+			b.removeWorker(w.Address)
+			b.Lost = append(b.Lost, gol.WorkerLost{CompletedTurns: turn, Address: w.Address, Reason: reason.Error()})
+			return
+		}
+	}
+}
+
+// Deregister removes a worker that is shutting down, whose rows go to the other workers from the next turn on.
+// The world is pulled back first, while the worker is still there to answer for its strip.
+func (b *Broker) Deregister(req gol.Registration, res *gol.Response) error {
+	turnMutex.Lock()
+	defer turnMutex.Unlock()
+	for _, s := range b.layout {
+		if s.worker.Address == req.Address {
+			if world, err := b.gather(b.Turn); err == nil {
+				b.world, b.layout = world, nil
+			}
+			break
+		}
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	b.removeWorker(req.Address)
+	return nil
+}
+
+// removeWorker removes and disconnects the worker at address, if there is one. The mutex must be held.
+func (b *Broker) removeWorker(address string) {
+	for i, w := range b.Workers {
+		if w.Address == address {
+			w.Client.Close()
+			b.Workers = append(b.Workers[:i:i], b.Workers[i+1:]...)
+			return
+		}
+	}
+}
+
+// splitRows divides the rows [start, end) into strips in proportion to the capacity of each worker.
+// Every strip has at least least rows, so with more workers than fit the last ones get none,
+// and with no workers at all the broker steps the rows itself.
+func splitRows(start, end, least int, workers []*Worker) []strip {
+	height := end - start
+	if len(workers) == 0 {
+		return []strip{{start: start, end: end}}
+	}
+	if len(workers) > height/least {
+		workers = workers[:height/least]
+	}
+	total := 0
+	for _, w := range workers {
+		total += w.Capacity
+	}
+	strips := make([]strip, len(workers))
+	from, share := 0, 0
+	for i, w := range workers {
+		share += w.Capacity
+		to := height * share / total
+		// leave enough rows for each worker still to come
+		if to < from+least {
+			to = from + least
+		}
+		if last := height - (len(workers)-i-1)*least; to > last {
+			to = last
+		}
+		strips[i] = strip{start: start + from, end: start + to, worker: w}
+		from = to
+	}
+	return strips
+}
+
+// balance has the world kept by the given workers, split in proportion to their capacity.
+// Each strip is at least radius rows high, so a batch always has a turn of halo rows to fetch from its neighbours.
+// If other workers keep it, it is pulled back from them first. With no workers, or a world too small for even one
+// strip's halos, the broker keeps the world itself. The turnMutex must be held.
+func (b *Broker) balance(workers []*Worker, turn, radius int) error {
+	var next []strip
+	if len(workers) > 0 && radius <= b.params.ImageHeight && radius <= b.params.ImageWidth {
+		next = splitRows(0, b.params.ImageHeight, radius, workers)
+	}
+	if sameLayout(b.layout, next) {
+		return nil
+	}
+	if b.layout != nil {
+		world, err := b.gather(turn)
+		if err != nil {
+			return err
+		}
+		b.world, b.layout = world, nil
+	}
+	if next == nil {
+		return nil
+	}
+	owners := make([]gol.Owner, len(next))
+	for i, s := range next {
+		owners[i] = gol.Owner{Start: s.start, End: s.end, Address: s.worker.Address}
+	}
+	requests := make([]gol.Request, len(next))
+	for i, s := range next {
+		requests[i] = gol.Request{Parameter: b.params, Turn: turn, Start: s.start, End: s.end, Owners: owners}
+		requests[i].World, requests[i].Grid = gol.PackRows(b.world[s.start:s.end], b.packed)
+	}
+	b.layout = next
+	if _, err := b.callAll(gol.StripLoad, requests, turn); err != nil {
+		b.layout = nil
+		return err
+	}
+	b.world = nil
+	return nil
+}
+
+// sameLayout reports whether two layouts have the same strips kept by the same workers.
+func sameLayout(a, b []strip) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// batchLimit returns the most turns a batch from turn can have. Batches stop at the last turn and at autosaves,
+// which would otherwise be stepped over, and their halos are no deeper than the thinnest strip or the world.
+// The turnMutex must be held.
+func (b *Broker) batchLimit(turn, radius int) int {
+	limit := b.params.Turns - turn
+	if every := b.params.AutosaveTurns; every > 0 && every-turn%every < limit {
+		limit = every - turn%every
+	}
+	thinnest := b.params.ImageWidth
+	if b.params.ImageHeight < thinnest {
+		thinnest = b.params.ImageHeight
+	}
+	for _, s := range b.layout {
+		if s.end-s.start < thinnest {
+			thinnest = s.end - s.start
+		}
+	}
+	if thinnest/radius < limit {
+		limit = thinnest / radius
+	}
+	return limit
+}
+
+// stepStrips has every worker step its strip from turn by batch turns, and counts the alive cells after.
+// The round trip is measured to pick the size of the next batch. The turnMutex must be held.
+func (b *Broker) stepStrips(turn, batch, radius int) error {
+	requests := make([]gol.Request, len(b.layout))
+	for i := range requests {
+		requests[i] = gol.Request{Turn: turn, Batch: batch}
+	}
+	began := time.Now()
+	responses, err := b.callAll(gol.StripStep, requests, turn)
+	if err != nil {
+		return err
+	}
+	roundTrip := time.Since(began)
+	count := 0
+	slowest := 0
+	changes := make([]gol.Change, batch)
+	for j := range changes {
+		changes[j].Turn = turn + j + 1
+	}
+	for i, res := range responses {
+		count += res.CellCount
+		if res.Elapsed > responses[slowest].Elapsed {
+			slowest = i
+		}
+		for j, change := range res.Changes {
+			changes[j].Cells = append(changes[j].Cells, change.Cells...)
+			changes[j].States = append(changes[j].States, change.States...)
+		}
+	}
+	// a strip of n rows is stepped over n+2*(batch-k)*radius rows on the kth turn of the batch
+	rows := batch * (b.layout[slowest].end - b.layout[slowest].start + (batch-1)*radius)
+	b.batcher.measure(roundTrip, responses[slowest].Elapsed, rows)
+	mutex.Lock()
+	b.CellCount = count
+	b.record(changes)
+	mutex.Unlock()
+	return nil
+}
+
+// stepLocal steps the world kept by the broker itself from turn. With an active region, a world with no active tile
+// is left as it is. The turnMutex must be held.
+func (b *Broker) stepLocal(stepper engine.Engine, topology engine.Topology, halo int, region *engine.ActiveRegion, turn int) {
+	change := gol.Change{Turn: turn + 1}
+	if region != nil && !region.RowsActive(0, len(b.world)) {
+		mutex.Lock()
+		b.record([]gol.Change{change})
+		mutex.Unlock()
+		return
+	}
+	next := processSegment(topology.Pad(b.world, 0, len(b.world), halo), stepper, halo)
+	count := 0
+	for y := range next {
+		for x, cell := range next[y] {
+			if cell != b.world[y][x] {
+				change.Cells = append(change.Cells, util.Cell{X: x, Y: y})
+				change.States = append(change.States, cell)
+			}
+			if cell == 255 {
+				count++
+			}
+		}
+	}
+	if region != nil {
+		region.Mark(change.Cells)
+	}
+	b.world = next
+	mutex.Lock()
+	b.CellCount = count
+	b.record([]gol.Change{change})
+	mutex.Unlock()
+}
+
+// currentWorld returns a copy of the world after turn, pulling it from the workers if they keep it.
+// The turnMutex must be held.
+func (b *Broker) currentWorld(turn int) ([][]byte, error) {
+	if b.life != nil {
+		return b.life.Grid().Unpack(), nil
+	}
+	if b.layout == nil {
+		return copySlice(b.world), nil
+	}
+	return b.gather(turn)
+}
+
+// gather pulls the strips kept by the workers after turn back into a whole world. The turnMutex must be held.
+func (b *Broker) gather(turn int) ([][]byte, error) {
+	requests := make([]gol.Request, len(b.layout))
+	for i, s := range b.layout {
+		requests[i] = gol.Request{Turn: turn, Start: s.start, End: s.end}
+	}
+	responses, err := b.callAll(gol.StripRows, requests, turn)
+	if err != nil {
+		return nil, err
+	}
+	world := make([][]byte, 0, b.params.ImageHeight)
+	for _, res := range responses {
+		world = append(world, gol.UnpackRows(res.World, res.Grid)...)
+	}
+	return world, nil
+}
+
+// callAll calls method on the worker of every strip at once, with requests[i] for strip i, and returns the responses.
+// A call is waited on for as long as its worker keeps sending heartbeats, however long stepping a batch takes, and
+// a worker that can't be reached or stops sending them is lost. A worker that answers with an error is still there,
+// e.g. it could not fetch rows from a lost worker, so it is only lost if no unreachable worker explains the failure.
+func (b *Broker) callAll(method string, requests []gol.Request, turn int) ([]*gol.Response, error) {
+	responses := make([]*gol.Response, len(b.layout))
+	errs := make([]error, len(b.layout))
+	var wg sync.WaitGroup
+	for i, s := range b.layout {
+		wg.Add(1)
+		go func(i int, w *Worker) {
+			defer wg.Done()
+			responses[i] = new(gol.Response)
+			call := w.Client.Go(method, requests[i], responses[i], nil)
+			heartbeat := time.NewTicker(gol.HeartbeatInterval)
+			defer heartbeat.Stop()
+			for {
+				select {
+				case <-call.Done:
+					errs[i] = call.Error
+					return
+				case <-heartbeat.C:
+					// monitor removes workers that stop sending heartbeats
+					if !b.registered(w) {
+						errs[i] = fmt.Errorf("lost while answering %v", method)
+						return
+					}
+				}
+			}
+		}(i, s.worker)
+	}
+	wg.Wait()
+
+	var failed error
+	unreachable := false
+	for _, err := range errs {
+		if err != nil {
+			failed = err
+			if _, answered := err.(rpc.ServerError); !answered {
+				unreachable = true
+			}
+		}
+	}
+	if failed == nil {
+		return responses, nil
+	}
+	mutex.Lock()
+	for i, err := range errs {
+		if _, answered := err.(rpc.ServerError); err != nil && (!answered || !unreachable) {
+			b.lose(b.layout[i].worker, turn, err)
+		}
+	}
+	mutex.Unlock()
+	return nil, failed
+}
+
+// processSegment steps the inner cells of a segment padded by halo rows and columns.
+// The padding already reflects the topology, so the segment is stepped as a plane and the padding cut off.
+func processSegment(segment [][]byte, stepper engine.Engine, halo int) [][]byte {
+	processed := stepper.Step(segment, halo, len(segment)-halo)
+	for i := range processed {
+		processed[i] = processed[i][halo : len(processed[i])-halo]
 	}
 	return processed
 }
 
+// autosaveDue reports whether the world after turn should be kept as an autosave, which is every
+// Params.AutosaveTurns turns or once Params.AutosaveInterval has passed since last, the time of the last one.
+func autosaveDue(p gol.Params, turn int, last *time.Time) bool {
+	if p.AutosaveTurns > 0 {
+		return turn%p.AutosaveTurns == 0
+	}
+	if p.AutosaveInterval > 0 && time.Since(*last) >= p.AutosaveInterval {
+		*last = time.Now()
+		return true
+	}
+	return false
+}
+
+// autosave is a copy of the world after turn, waiting for the controller to write it.
+type autosave struct {
+	world [][]byte
+	turn  int
+}
+
+// autosavesQueued is the most autosaves waiting to be collected. Past it the oldest are dropped,
+// as only the newest are kept on disk anyway, and nobody collects them while a session is left running.
+const autosavesQueued = 64
+
+// keepAutosave queues an autosave for the controller to collect.
+func (b *Broker) keepAutosave(world [][]byte, turn int) {
+	mutex.Lock()
+	b.Autosaves = append(b.Autosaves, autosave{world: world, turn: turn})
+	if len(b.Autosaves) > autosavesQueued {
+		b.Autosaves = b.Autosaves[len(b.Autosaves)-autosavesQueued:]
+	}
+	mutex.Unlock()
+}
+
 func (b *Broker) GolAliveCells(req gol.Request, res *gol.Response) error {
 	mutex.Lock()
 	defer mutex.Unlock()
+	res.Turns = b.Turn
+	res.CellCount = b.CellCount
+	res.Lost, b.Lost = b.Lost, nil
 	return nil
 }
 
 func (b *Broker) GolKey(req gol.Request, res *gol.Response) error {
 	var wg sync.WaitGroup
 	//broker := &Broker{}
-	if req.S {
+	if req.A {
+		// the autosaves up to req.Saved have been written, and the oldest one after it is next
 		mutex.Lock()
-		res.Turns = b.Turn
-		res.World = copySlice(b.CombinedWorld)
+		for len(b.Autosaves) > 0 && b.Autosaves[0].turn <= req.Saved {
+			b.Autosaves = b.Autosaves[1:]
+		}
+		if len(b.Autosaves) > 0 {
+			res.Turns = b.Autosaves[0].turn
+			res.World = b.Autosaves[0].world
+		}
 		mutex.Unlock()
+	} else if req.S {
+		// The world is pulled from the workers between turns, so it is the world after b.Turn
+		turnMutex.Lock()
+		world, err := b.currentWorld(b.Turn)
+		res.Turns = b.Turn
+		turnMutex.Unlock()
+		if err != nil {
+			return err
+		}
+		res.World = world
 	} else if req.P {
 		mutex.Lock()
+		if req.Session != b.Session {
+			// the session hasn't started yet, and is paused once it does
+			if b.pausing == req.Session {
+				b.pausing = ""
+			} else {
+				b.pausing = req.Session
+			}
+			mutex.Unlock()
+			return nil
+		}
 		b.Pause = !b.Pause
+		paused, resume := b.Pause, b.Resume
+		res.Turns = b.Turn
 		mutex.Unlock()
-		if !b.Pause {
-			b.Resume <- true
+		if !paused {
+			resume <- true
 		}
 	} else if req.K {
-		for _, client := range b.Clients {
+		// The world is pulled back for the controller to output before the workers are shut down
+		turnMutex.Lock()
+		if world, err := b.currentWorld(b.Turn); err == nil {
+			res.Turns = b.Turn
+			res.World = world
+		}
+		mutex.Lock()
+		workers := b.Workers
+		mutex.Unlock()
+		for _, w := range workers {
 			wg.Add(1)
 			go func(client *rpc.Client) {
 				defer wg.Done()
-				client.Call(gol.Key, req, res)
-			}(client)
+				// each worker answers into its own response, res is the controller's
+				client.Call(gol.Key, req, new(gol.Response))
+			}(w.Client)
 		}
 		wg.Wait()
 		os.Exit(0)
@@ -260,13 +996,11 @@ Continuously accepts and serves incoming RPC requests from the Local Controller.
 Overall, the main function sets up the necessary infrastructure for the Broker to function as an intermediary between the Local Controller and the GOL workers.
 */
 func main() {
-	clients := make([]*rpc.Client, 4)
-	// Broker Initialization
+	// Broker Initialization, workers join it with Register
 	broker := &Broker{
 		Resume:    make(chan bool),
 		Turn:      0,
 		CellCount: 0,
-		Clients:   clients,
 		//LoadPredictor: &LoadPredictor{
 		//	weights: []float64{rand.Float64(), rand.Float64()}, // 随机初始化权重
 		//},
@@ -276,6 +1010,7 @@ func main() {
 	if err := rpc.Register(broker); err != nil {
 		return
 	}
+	go broker.monitor()
 
 	// Parse the port flag
 	port := flag.String("port", "8030", "port to listen on")
@@ -291,22 +1026,3 @@ func main() {
 	// Accept RPC connections
 	rpc.Accept(listener)
 }
-
-//// Send halo rows to neighboring workers
-//// If the current client is not the first, send the first row of the current segment to the previous client
-//if i > 0 {
-//	previousClient := b.Clients[i-1]
-//	previousClient.Call("Worker.ReceiveHalo", world[i*haloSize-1], nil)
-//}
-//// If the current client is not the last, send the last row of the current segment to the next client
-//if i < len(b.Clients)-1 {
-//	nextClient := b.Clients[i+1]
-//	nextClient.Call("Worker.ReceiveHalo", world[(i+1)*haloSize], nil)
-//}
-//
-//// Create a new response object
-//newRes := new(gol.Response)
-//// Call the ProcessGol method on the current client to process its assigned world segment
-//client.Call("Worker.ProcessGol", newReq, newRes)
-//// Collect results
-//// ... existing code to collect and merge results ...
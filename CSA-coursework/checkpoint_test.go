@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestCheckpoint tests that a run resumed from a golc checkpoint carries on with the same rule and topology,
+// counting turns from where the checkpoint was written.
+func TestCheckpoint(t *testing.T) {
+	tests := []struct {
+		size     int
+		rule     string
+		topology string
+		engine   string
+	}{
+		{64, "", "", ""},
+		{64, "", "", "packed"},
+		{16, "B2/S345/C4", "", ""},
+		{16, "", "klein", "naive"},
+	}
+	for _, test := range tests {
+		name := fmt.Sprintf("%dx%d-%v-%v-%v", test.size, test.size, test.rule, test.topology, test.engine)
+		t.Run(name, func(t *testing.T) {
+			p := gol.Params{ImageWidth: test.size, ImageHeight: test.size, Turns: 50, Threads: 2,
+				Rule: test.rule, Topology: test.topology, Engine: test.engine, Format: "golc", OutputDir: t.TempDir()}
+			runFinal(p)
+			p.Turns = 100
+			expected := runFinal(p)
+
+			// only the turns, engine and output come from the resumed run's own params
+			resumed := gol.Params{Turns: 100, Threads: 2, Engine: test.engine, OutputDir: t.TempDir(),
+				Resume: filepath.Join(p.OutputDir, fmt.Sprintf("%dx%dx50.golc", test.size, test.size))}
+			events := make(chan gol.Event)
+			go gol.Run(resumed, events, nil)
+			var alive []util.Cell
+			firstTurn, finalTurn := -1, -1
+			for event := range events {
+				switch e := event.(type) {
+				case gol.TurnComplete:
+					if firstTurn < 0 {
+						firstTurn = e.CompletedTurns
+					}
+				case gol.FinalTurnComplete:
+					finalTurn = e.CompletedTurns
+					alive = e.Alive
+				}
+			}
+			if firstTurn != 51 || finalTurn != 100 {
+				t.Errorf("ERROR: expected turns 51 to 100 after resuming at 50, got %d to %d", firstTurn, finalTurn)
+			}
+			assertEqualBoard(t, alive, expected, gol.Params{ImageWidth: test.size, ImageHeight: test.size, Turns: 100})
+		})
+	}
+
+	t.Run("missing", func(t *testing.T) {
+		ioErrors, final, err := runErrors(gol.Params{Turns: 10, Threads: 1, Resume: "out/missing.golc"})
+		if err == nil || len(ioErrors) != 1 || final {
+			t.Errorf("ERROR: expected a missing checkpoint to stop the run with one IOError, got %v and %v", err, ioErrors)
+		}
+	})
+}
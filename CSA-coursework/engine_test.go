@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestEngine tests the 64x64 image on 100 turns through Run with each engine, a Larger than Life rule and a topology,
+// which the gol/engine tests check in more depth.
+func TestEngine(t *testing.T) {
+	tests := []struct {
+		name  string
+		p     gol.Params
+		check string
+	}{
+		{"naive", gol.Params{Engine: "naive", Threads: 1}, "check/images"},
+		{"parallel", gol.Params{Engine: "parallel", Threads: 4}, "check/images"},
+		{"packed", gol.Params{Engine: "packed", Threads: 4}, "check/images"},
+		{"active", gol.Params{Engine: "active", Threads: 1}, "check/images"},
+		{"hashlife", gol.Params{Engine: "hashlife", Threads: 1}, "check/images"},
+		{"ltl", gol.Params{Rule: "R1,C0,M1,S3..4,B3,NM", Threads: 4}, "check/images"},
+		{"klein", gol.Params{Topology: "klein", Threads: 4}, "check/topology/klein"},
+	}
+	for _, test := range tests {
+		p := test.p
+		p.ImageWidth, p.ImageHeight, p.Turns = 64, 64, 100
+		expectedAlive := readAliveCells(
+			test.check+"/"+fmt.Sprintf("%vx%vx%v.pgm", p.ImageWidth, p.ImageHeight, p.Turns),
+			p.ImageWidth,
+			p.ImageHeight,
+		)
+		t.Run(test.name, func(t *testing.T) {
+			assertEqualBoard(t, runFinal(p), expectedAlive, p)
+		})
+	}
+}
+
+// TestGenerations checks that a Generations rule writes its dying states as intermediate grey levels.
+func TestGenerations(t *testing.T) {
+	p := gol.Params{ImageWidth: 16, ImageHeight: 16, Turns: 1, Threads: 4, Rule: "B2/S345/C4"}
+	emptyOutFolder()
+	runFinal(p)
+
+	data, err := os.ReadFile(fmt.Sprintf("out/%vx%vx%v.pgm", p.ImageWidth, p.ImageHeight, p.Turns))
+	util.Check(err)
+	pixels := data[len(data)-p.ImageWidth*p.ImageHeight:]
+	dying := 0
+	for _, pixel := range pixels {
+		switch pixel {
+		case 0, 255:
+		case 170:
+			dying++
+		default:
+			t.Fatalf("ERROR: unexpected grey level %v for a 4 state rule", pixel)
+		}
+	}
+	if dying == 0 {
+		t.Error("ERROR: expected some cells to be in the first dying state after 1 turn")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"sync"
 	"time"
+	"uk.ac.bris.cs/gameoflife/gol/engine"
 	"uk.ac.bris.cs/gameoflife/util"
 )
 
@@ -16,165 +17,294 @@ type distributorChannels struct {
 	ioFilename chan<- string
 	ioOutput   chan<- uint8
 	ioInput    <-chan uint8
+	ioError    <-chan error
+	ioSize     <-chan int
+	ioTurn     chan<- int
 	key        <-chan rune
 }
 
 var wg sync.WaitGroup
 
-func distributor(p Params, c distributorChannels) {
+// ioMutex keeps images written from different goroutines from interleaving on the io channels.
+var ioMutex sync.Mutex
+
+// distributor returns an error if the simulation couldn't start, or if the final image couldn't be written.
+// The broker runs the turns as a session that carries on if the controller quits with 'q', and which another
+// controller can take over with Params.Attach.
+func distributor(p Params, c distributorChannels) error {
 	//var mutex sync.Mutex
 	kill := false
 	pause := false
-	// Initialize IO
-	c.ioCommand <- ioInput
-	c.ioFilename <- fmt.Sprintf("%dx%d", p.ImageWidth, p.ImageHeight)
-
-	broker := "12.7.0.0.1:8080"
-	// Create initial world
-	world := make([][]byte, p.ImageHeight)
-	for i := range world {
-		world[i] = make([]byte, p.ImageWidth)
+	if err := engine.Check(p.Engine); err != nil {
+		return abort(c, err)
+	}
+	rule, err := engine.ParseRule(p.Rule)
+	if err != nil {
+		return abort(c, err)
 	}
 
-	// Close the dial when everything is executed
-	client, _ := rpc.Dial("tcp", broker)
+	// Connect to the broker
+	broker := p.Broker
+	if broker == "" {
+		broker = DefaultBroker
+	}
+	client, err := rpc.Dial("tcp", broker)
+	if err != nil {
+		fmt.Printf("Failed to connect to GOL server: %v\n", err)
+		return abort(c, err)
+	}
 	defer func() {
 		if err := client.Close(); err != nil {
 			fmt.Println("Error closing RPC client:", err)
 		}
 	}()
 
-	// Read initial state
-	for y := 0; y < p.ImageHeight; y++ {
-		for x := 0; x < p.ImageWidth; x++ {
-			world[y][x] = <-c.ioInput
+	// Create request and response objects
+	var request Request
+	var call string
+	response := new(Response)
+	// The controller's own copy of the world, after the turns it has reported
+	var current [][]byte
+	if p.Attach != "" {
+		// Run has the params of the session, whose world is read back here and final state then waited on
+		attached := new(Response)
+		if err := client.Call(BrokerKey, Request{S: true}, attached); err != nil {
+			return abort(c, err)
 		}
-	}
+		p.StartTurn = attached.Turns
+		if err := sendWorld(c, p, attached.World); err != nil {
+			return abort(c, err)
+		}
+		current = attached.World
+		request = Request{Session: p.Attach}
+		call = BrokerWait
+	} else {
+		// Initialize IO
+		c.ioCommand <- ioInput
+		if p.Resume != "" {
+			c.ioFilename <- p.Resume
+		} else if p.Soup > 0 {
+			c.ioFilename <- soupName(p)
+		} else if p.Stream {
+			c.ioFilename <- "stdin"
+		} else if p.Pattern != "" {
+			c.ioFilename <- p.Pattern
+		} else {
+			c.ioFilename <- fmt.Sprintf("%dx%d", p.ImageWidth, p.ImageHeight)
+		}
+		if err := <-c.ioError; err != nil {
+			c.events <- IOError{CompletedTurns: 0, Err: err}
+			return abort(c, err)
+		}
+		// Params may leave the size of the world to be read from the file, so the io goroutine reports it
+		p.ImageWidth = <-c.ioSize
+		p.ImageHeight = <-c.ioSize
 
-	// Connect to GOL server
-	client, err := rpc.Dial("tcp", "127.0.0.1:8080")
-	if err != nil {
-		fmt.Printf("Failed to connect to GOL server: %v\n", err)
-		return
-	}
-	defer client.Close()
+		// Create initial world
+		world := make([][]byte, p.ImageHeight)
+		for i := range world {
+			world[i] = make([]byte, p.ImageWidth)
+		}
+		// Read initial state
+		for y := 0; y < p.ImageHeight; y++ {
+			for x := 0; x < p.ImageWidth; x++ {
+				world[y][x] = <-c.ioInput
+			}
+		}
 
-	// Create request and response objects
-	request := Request{
-		World:     world,
-		Parameter: p,
+		if err := sendWorld(c, p, world); err != nil {
+			return abort(c, err)
+		}
+		current = copySlice(world)
+		request = Request{
+			World:     world,
+			Parameter: p,
+			Session:   newSession(),
+		}
+		call = Initializer
+		c.events <- Session{CompletedTurns: p.StartTurn, ID: request.Session}
 	}
-	response := new(Response)
+	session := request.Session
+
+	// The pollers below stop before the events channel is closed
+	var pollers sync.WaitGroup
+	stopPolling := make(chan bool)
 
 	// Set up ticker for alive cells count
 	ticker := time.NewTicker(2 * time.Second)
 	defer ticker.Stop()
 
-	//TODO: Whether need to add mutex or  else neede to be fixed?
 	// Start a goroutine to periodically request alive cell count
-
+	pollers.Add(1)
 	go func() {
-		for range ticker.C {
+		defer pollers.Done()
+		for {
+			select {
+			case <-stopPolling:
+				return
+			case <-ticker.C:
+			}
 			// Check if the process is not paused or killed
-			if !pause && !kill {
-				err := client.Call(BrokerAliveCells, request, &response)
-				if err != nil {
-					return
-				}
-				// Send an event with the current number of alive cells and completed turns
-				c.events <- AliveCellsCount{
-					CompletedTurns: response.Turns,
-					CellsCount:     response.CellCount}
+			if pause || kill {
+				continue
+			}
+			tick := new(Response)
+			if err := client.Call(BrokerAliveCells, Request{}, tick); err != nil {
+				return
+			}
+			// Send an event with the current number of alive cells and completed turns
+			c.events <- AliveCellsCount{
+				CompletedTurns: tick.Turns,
+				CellsCount:     tick.CellCount}
+			// Workers lost since the last tick, whose rows the broker has already given to the others
+			for _, lost := range tick.Lost {
+				c.events <- lost
 			}
 		}
 	}()
 
-	//go func() {
-	//	for range ticker.C {
-	//		countResp := new(Response)
-	//		err := client.Call(Live, request, countResp)
-	//		if err != nil {
-	//			fmt.Printf("CountAliveCell error: %v\n", err)
-	//			continue
-	//		}
-	//		// Send alive cells count event
-	//		c.events <- AliveCellsCount{
-	//			CompletedTurns: countResp.Turns,
-	//			CellsCount:     countResp.CellCount,
-	//		}
-	//	}
-	//}()
-
+	// The broker queues the cells changed on each turn, which are followed turn by turn. When they aren't all
+	// queued, e.g. after a hashlife jump, it answers the whole world instead, which is caught up with in one go.
+	reported := p.StartTurn
+	follow := func() {
+		live := new(Response)
+		if err := client.Call(Live, Request{Session: session, Turn: reported}, live); err != nil {
+			return
+		}
+		if live.World != nil && live.Turns > reported {
+			change := Change{Turn: live.Turns}
+			for y := range live.World {
+				for x, cell := range live.World[y] {
+					if cell != current[y][x] {
+						change.Cells = append(change.Cells, util.Cell{X: x, Y: y})
+						change.States = append(change.States, cell)
+					}
+				}
+			}
+			current = live.World
+			sendChange(c, rule, reported, change)
+			reported = live.Turns
+			c.events <- TurnComplete{reported}
+		}
+		for _, change := range live.Changes {
+			if change.Turn != reported+1 {
+				continue
+			}
+			for i, cell := range change.Cells {
+				current[cell.Y][cell.X] = change.States[i]
+			}
+			sendChange(c, rule, reported, change)
+			reported = change.Turn
+			c.events <- TurnComplete{reported}
+		}
+	}
 	newTicker := time.NewTicker(50 * time.Millisecond)
 	defer newTicker.Stop()
-	previousWorld := copySlice(world)
+	pollers.Add(1)
 	go func() {
-		for range newTicker.C {
-			request := Request{World: previousWorld}
-			err := client.Call(Live, request, &response)
-			defer client.Close()
-			if err != nil {
-				fmt.Println(err)
+		defer pollers.Done()
+		for {
+			select {
+			case <-stopPolling:
+				return
+			case <-newTicker.C:
 			}
-			// Compare the previous world state with the new state
-			for i := 0; i < p.ImageHeight; i++ {
-				for j := 0; j < p.ImageWidth; j++ {
-					// If a cell has changed, send a CellFlipped event
-					if previousWorld[i][j] != response.World[i][j] {
-						c.events <- CellFlipped{CompletedTurns: response.Turns, Cell: util.Cell{X: j, Y: i}}
-					}
+			follow()
+		}
+	}()
+
+	// The broker queues a copy of the world whenever an autosave is due, which are collected and written here in turn.
+	// Once stopped, the ones still queued are written before the final events.
+	autosaveStop := make(chan bool)
+	autosaveDone := make(chan bool)
+	go func() {
+		defer close(autosaveDone)
+		if p.AutosaveTurns == 0 && p.AutosaveInterval == 0 {
+			return
+		}
+		saved := newAutosaves(p)
+		poll := time.NewTicker(50 * time.Millisecond)
+		defer poll.Stop()
+		savedTurn := p.StartTurn
+		stopping := false
+		for {
+			saveResponse := new(Response)
+			err := client.Call(BrokerKey, Request{A: true, Saved: savedTurn}, saveResponse)
+			if err != nil || saveResponse.World == nil {
+				if stopping {
+					return
+				}
+				select {
+				case <-autosaveStop:
+					stopping = true
+				case <-poll.C:
+				}
+				continue
+			}
+			savedTurn = saveResponse.Turns
+			name, file := autosaveFile(p, savedTurn, time.Now())
+			if saveImage(c, saveResponse.World, p, savedTurn, name, file) == nil {
+				if err := saved.add(outputPath(p, file)); err != nil {
+					c.events <- IOError{CompletedTurns: savedTurn, Err: err}
 				}
 			}
-			previousWorld = copySlice(previousWorld)
-			c.events <- TurnComplete{response.Turns}
-
 		}
 	}()
+
 	exitSignal := make(chan bool)
+	// 'q' leaves the session running
+	detach := make(chan bool, 1)
 	// Handle keypress events
 	go func() {
+		paused := false
 		for {
 			select {
 			case key := <-c.key:
 				switch key {
 				case 's':
-					request.S = true
-					err := client.Call(BrokerKey, request, response)
+					response := new(Response)
+					err := client.Call(BrokerKey, Request{S: true}, response)
 					if err != nil {
 						return
 					}
 
-					outputPGM(c, response.World, p, response.Turns)
-				case 'q':
-					c.events <- FinalTurnComplete{CompletedTurns: response.Turns, Alive: response.AliveCells}
-					//check whether io is empty
-					c.ioCommand <- ioCheckIdle
-					<-c.ioIdle
-
-					c.events <- StateChange{response.Turns, Quitting}
+					outputImage(c, response.World, p, response.Turns, "")
+				case 'n':
+					response := new(Response)
+					err := client.Call(BrokerKey, Request{S: true}, response)
+					if err != nil {
+						return
+					}
 
-					close(exitSignal)
+					outputImage(c, response.World, p, response.Turns, "png")
+				case 'q':
+					// the broker lets a new session replace one left running
+					client.Call(BrokerDetach, Request{Session: session}, new(Response))
+					detach <- true
+					return
 				case 'p':
-					requestKey := Request{P: true}
+					requestKey := Request{P: true, Session: session}
+					response := new(Response)
 					err := client.Call(BrokerKey, requestKey, response)
 					if err != nil {
 						return
 					}
-					if response.Turns%2 == 0 {
+					paused = !paused
+					if paused {
 						fmt.Println("Paused at turn:", response.Turns)
 						c.events <- StateChange{response.Turns, Paused}
 					} else {
 						fmt.Println("Continuing")
-						c.events <- StateChange{response.Turns, Paused}
+						c.events <- StateChange{response.Turns, Executing}
 					}
 				case 'k':
 					wg.Add(1)
-					request.K = true
-					err := client.Call(BrokerKey, request, response)
+					response := new(Response)
+					err := client.Call(BrokerKey, Request{K: true}, response)
 					if err != nil {
 						return
 					}
-					outputPGM(c, response.World, p, response.Turns)
+					outputImage(c, response.World, p, response.Turns, "")
 					kill = true
 					c.ioCommand <- ioCheckIdle
 					<-c.ioIdle
@@ -189,28 +319,70 @@ func distributor(p Params, c distributorChannels) {
 		}
 	}()
 
-	// Make the main RPC call to process all turns
-	err = client.Call(BrokerKey, request, response)
-	if err != nil {
+	// Make the main RPC call to process all turns, or to wait for the session attached to
+	done := client.Go(call, request, response, nil)
+	select {
+	case <-done.Done:
+	case <-detach:
+		// the world so far is the one the controller has followed the session to
+		close(stopPolling)
+		pollers.Wait()
+		follow()
+		outputImage(c, current, p, reported, "")
+		close(autosaveStop)
+		<-autosaveDone
+		// wait for the image of the world so far
+		ioMutex.Lock()
+		ioMutex.Unlock()
+		c.events <- FinalTurnComplete{CompletedTurns: reported, Alive: aliveCells(current)}
+		c.ioCommand <- ioCheckIdle
+		<-c.ioIdle
+		c.events <- Session{CompletedTurns: reported, ID: session, Left: true}
+		c.events <- StateChange{reported, Quitting}
+		close(c.events)
+		return nil
+	}
+	close(stopPolling)
+	pollers.Wait()
+	if err := done.Error; err != nil {
 		fmt.Printf("ProcessWorld error: %v\n", err)
-		return
+		close(autosaveStop)
+		<-autosaveDone
+		return abort(c, err)
+	}
+	// turns completed since the last poll
+	for reported < response.Turns {
+		before := reported
+		follow()
+		if reported == before {
+			break
+		}
 	}
 
 	// Wait for completion
 	wg.Wait()
+	close(autosaveStop)
+	<-autosaveDone
+	var outputErr error
 	// Check if the specified last turn is finished
 	if response.End {
 		// Output final state
+		ioMutex.Lock()
 		c.ioCommand <- ioOutput
-		outputFilename := fmt.Sprintf("%dx%dx%d", p.ImageWidth, p.ImageHeight, p.Turns)
+		imageName := outputName(p, p.Turns, time.Now())
+		outputFilename := imageFile(p, imageName, "")
 		c.ioFilename <- outputFilename
+		c.ioTurn <- p.Turns
 
-		// Write the world state to the PGM file
+		// Write the world state to the image file
 		for y := 0; y < p.ImageHeight; y++ {
 			for x := 0; x < p.ImageWidth; x++ {
 				c.ioOutput <- response.World[y][x]
 			}
 		}
+		sendAges(c, p, outputFilename)
+		outputErr = <-c.ioError
+		ioMutex.Unlock()
 
 		// Send final events
 		c.events <- FinalTurnComplete{
@@ -218,10 +390,15 @@ func distributor(p Params, c distributorChannels) {
 			Alive:          response.AliveCells,
 		}
 
-		// Notify that image output is complete
-		c.events <- ImageOutputComplete{
-			CompletedTurns: p.Turns,
-			Filename:       outputFilename,
+		// Notify that image output is complete, or why it failed
+		if outputErr != nil {
+			c.events <- IOError{CompletedTurns: p.Turns, Err: outputErr}
+		} else {
+			c.events <- ImageOutputComplete{
+				CompletedTurns: p.Turns,
+				Filename:       imageName,
+				Path:           outputPath(p, outputFilename),
+			}
 		}
 
 		// Ensure IO is complete
@@ -244,31 +421,97 @@ func distributor(p Params, c distributorChannels) {
 		c.events <- StateChange{response.Turns, Quitting}
 		close(c.events)
 	}
+	return outputErr
+}
+
+// abort stops the distributor before the first turn, telling the user it is quitting.
+func abort(c distributorChannels, err error) error {
+	c.events <- StateChange{CompletedTurns: 0, NewState: Quitting}
+	close(c.events)
+	return err
 }
 
 //func handleKeyPress(k rune, client *rpc.Client, p Params, c distributorChannels) {
 //
 //}
-func outputPGM(c distributorChannels, world [][]byte, p Params, turns int) {
+// outputImage outputs the world state as an image in the given format, or Params.Format if that is empty.
+func outputImage(c distributorChannels, world [][]byte, p Params, turns int, format string) {
 	// Send the command to start output
+	ioMutex.Lock()
 	c.ioCommand <- ioOutput
-	// Format the filename with dimensions and turns
-	fileName := fmt.Sprintf("%dx%dx%d", p.ImageWidth, p.ImageHeight, turns)
+	// Fill in the filename template with dimensions and turns
+	name := outputName(p, turns, time.Now())
+	fileName := imageFile(p, name, format)
 	c.ioFilename <- fileName
+	c.ioTurn <- turns
 	// Use a goroutine to handle the output asynchronously
 	go func() {
+		defer ioMutex.Unlock()
 		// Output the world state to the ioOutput channel
 		for y := 0; y < p.ImageHeight; y++ {
 			for x := 0; x < p.ImageWidth; x++ {
 				c.ioOutput <- world[y][x]
 			}
 		}
+		sendAges(c, p, fileName)
 
-		// Notify that the image output is complete
-		c.events <- ImageOutputComplete{CompletedTurns: turns, Filename: fileName}
+		// Notify that the image output is complete, or why it failed
+		if err := <-c.ioError; err != nil {
+			c.events <- IOError{CompletedTurns: turns, Err: err}
+			return
+		}
+		c.events <- ImageOutputComplete{CompletedTurns: turns, Filename: name, Path: outputPath(p, fileName)}
 	}()
 }
 
+// saveImage has the io goroutine write the world to filename, whose extension gives the format,
+// and waits for it to be written before reporting the image as name.
+func saveImage(c distributorChannels, world [][]byte, p Params, turns int, name, filename string) error {
+	ioMutex.Lock()
+	defer ioMutex.Unlock()
+	c.ioCommand <- ioOutput
+	c.ioFilename <- filename
+	c.ioTurn <- turns
+	for y := 0; y < p.ImageHeight; y++ {
+		for x := 0; x < p.ImageWidth; x++ {
+			c.ioOutput <- world[y][x]
+		}
+	}
+	sendAges(c, p, filename)
+	if err := <-c.ioError; err != nil {
+		c.events <- IOError{CompletedTurns: turns, Err: err}
+		return err
+	}
+	c.events <- ImageOutputComplete{CompletedTurns: turns, Filename: name, Path: outputPath(p, filename)}
+	return nil
+}
+
+// sendAges sends the ages of the cells when the image needs them for the heat palette.
+// The broker doesn't keep track of ages, so every alive cell is drawn as newborn.
+func sendAges(c distributorChannels, p Params, filename string) {
+	if !sendsAges(p, formatOf(filename, p.Format)) {
+		return
+	}
+	for y := 0; y < p.ImageHeight; y++ {
+		for x := 0; x < p.ImageWidth; x++ {
+			c.ioOutput <- 0
+		}
+	}
+}
+
+// sendChange tells the GUI about the cells that changed on the turn after turn, as CellsFlipped,
+// or as CellsUpdated when they may be in the dying states of a Generations rule.
+func sendChange(c distributorChannels, rule engine.Rule, turn int, change Change) {
+	if len(change.Cells) == 0 {
+		return
+	}
+	if rule.Generations() {
+		c.events <- CellsUpdated{CompletedTurns: turn, Cells: change.Cells, States: change.States}
+	} else {
+		c.events <- CellsFlipped{CompletedTurns: turn, Cells: change.Cells}
+	}
+}
+
 // copySlice creates a deep copy of a 2D byte slice
 func copySlice(src [][]byte) [][]byte {
 	dst := make([][]byte, len(src))
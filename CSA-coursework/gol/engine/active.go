@@ -0,0 +1,169 @@
+package engine
+
+import "uk.ac.bris.cs/gameoflife/util"
+
+// DefaultTileSize is the side of the square tiles tracked by an ActiveRegion.
+const DefaultTileSize = 16
+
+// ActiveRegion tracks which tiles of the world can change on the next turn.
+// A cell's next value only depends on its neighbourhood, so a cell whose neighbourhood did not change
+// last turn keeps its value. Only the tiles within reach of a flipped cell are active and need recomputing,
+// the rest of the world is still-life or empty and is copied across unchanged.
+type ActiveRegion struct {
+	width, height  int
+	tileSize       int
+	tilesX, tilesY int
+	rule           Rule
+	topology       Topology
+	active         []bool
+}
+
+// NewActiveRegion returns a tracker for a width x height world with every tile active.
+func NewActiveRegion(width, height, tileSize int, rule Rule, topology Topology) *ActiveRegion {
+	a := &ActiveRegion{
+		width:    width,
+		height:   height,
+		tileSize: tileSize,
+		tilesX:   (width + tileSize - 1) / tileSize,
+		tilesY:   (height + tileSize - 1) / tileSize,
+		rule:     rule,
+		topology: topology,
+	}
+	a.active = make([]bool, a.tilesX*a.tilesY)
+	for i := range a.active {
+		a.active[i] = true
+	}
+	return a
+}
+
+// Active returns the number of active tiles.
+func (a *ActiveRegion) Active() int {
+	count := 0
+	for _, active := range a.active {
+		if active {
+			count++
+		}
+	}
+	return count
+}
+
+// RowsActive reports whether any tile overlapping rows [start, end) is active.
+func (a *ActiveRegion) RowsActive(start, end int) bool {
+	if start >= end {
+		return false
+	}
+	for ty := start / a.tileSize; ty <= (end-1)/a.tileSize; ty++ {
+		for tx := 0; tx < a.tilesX; tx++ {
+			if a.active[ty*a.tilesX+tx] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Mark replaces the active tiles with those within the rule's radius of the given flipped cells.
+func (a *ActiveRegion) Mark(flipped []util.Cell) {
+	for i := range a.active {
+		a.active[i] = false
+	}
+	radius := a.rule.Radius
+	for _, cell := range flipped {
+		rows := a.samples(cell.Y, radius, a.height)
+		cols := a.samples(cell.X, radius, a.width)
+		for _, row := range rows {
+			for _, col := range cols {
+				if row, col, ok := a.topology.Wrap(row, col, a.height, a.width); ok {
+					a.active[(row/a.tileSize)*a.tilesX+col/a.tileSize] = true
+				}
+			}
+		}
+	}
+}
+
+// samples returns positions across [centre-radius, centre+radius] no more than a tile apart,
+// including both ends and the cells either side of each edge of the world, so that every
+// tile the interval touches after wrapping contains at least one of them.
+func (a *ActiveRegion) samples(centre, radius, size int) []int {
+	low, high := centre-radius, centre+radius
+	var positions []int
+	for pos := low; pos < high; pos += a.tileSize {
+		positions = append(positions, pos)
+	}
+	positions = append(positions, high)
+	for _, edge := range []int{-1, 0, size - 1, size} {
+		if edge > low && edge < high {
+			positions = append(positions, edge)
+		}
+	}
+	return positions
+}
+
+// Step returns the next state of the world and the cells that changed, recomputing only the active tiles.
+// The active tiles are then moved on to those around the changed cells.
+func (a *ActiveRegion) Step(world [][]byte) ([][]byte, []util.Cell) {
+	next := copySlice(world)
+	var flipped []util.Cell
+	for ty := 0; ty < a.tilesY; ty++ {
+		start := ty * a.tileSize
+		end := start + a.tileSize
+		if end > a.height {
+			end = a.height
+		}
+		// Larger than Life rows are cheapest to compute a whole band at a time
+		var band [][]byte
+		if a.rule.LargerThanLife() && a.RowsActive(start, end) {
+			band = a.rule.NextRows(world, start, end, a.topology)
+		}
+		for tx := 0; tx < a.tilesX; tx++ {
+			if !a.active[ty*a.tilesX+tx] {
+				continue
+			}
+			left := tx * a.tileSize
+			right := left + a.tileSize
+			if right > a.width {
+				right = a.width
+			}
+			for y := start; y < end; y++ {
+				for x := left; x < right; x++ {
+					var cell byte
+					if band != nil {
+						cell = band[y-start][x]
+					} else {
+						cell = a.rule.Next(world[y][x], a.neighbours(world, x, y))
+					}
+					if cell != world[y][x] {
+						next[y][x] = cell
+						flipped = append(flipped, util.Cell{X: x, Y: y})
+					}
+				}
+			}
+		}
+	}
+	a.Mark(flipped)
+	return next, flipped
+}
+
+// neighbours counts the alive cells among the 8 neighbours of (x, y).
+func (a *ActiveRegion) neighbours(world [][]byte, x, y int) int {
+	alive := 0
+	for dy := -1; dy <= 1; dy++ {
+		for dx := -1; dx <= 1; dx++ {
+			if dx == 0 && dy == 0 {
+				continue
+			}
+			row, col := y+dy, x+dx
+			if row < 0 || row >= a.height || col < 0 || col >= a.width {
+				var ok bool
+				row, col, ok = a.topology.Wrap(row, col, a.height, a.width)
+				if !ok {
+					continue
+				}
+			}
+			if world[row][col] == 255 {
+				alive++
+			}
+		}
+	}
+	return alive
+}
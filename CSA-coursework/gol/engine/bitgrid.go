@@ -0,0 +1,269 @@
+package engine
+
+import (
+	"math/bits"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// BitGrid is a world packed 64 cells to a word: the cell at (x, y) is bit x%64 of Words[y*Stride+x/64].
+// Bits past the width of a row are always zero. The fields are exported so a BitGrid can be sent over RPC,
+// where it is 8 times smaller than the equivalent [][]byte.
+type BitGrid struct {
+	Width, Height int
+	Stride        int
+	Words         []uint64
+}
+
+// NewBitGrid returns an empty width x height grid.
+func NewBitGrid(width, height int) *BitGrid {
+	stride := (width + 63) / 64
+	return &BitGrid{
+		Width:  width,
+		Height: height,
+		Stride: stride,
+		Words:  make([]uint64, stride*height),
+	}
+}
+
+// PackWorld packs a world of bytes into a BitGrid, treating 255 as alive and anything else as dead.
+func PackWorld(world [][]byte) *BitGrid {
+	g := NewBitGrid(len(world[0]), len(world))
+	for y, row := range world {
+		for x, cell := range row {
+			if cell == 255 {
+				g.Words[y*g.Stride+x/64] |= 1 << uint(x%64)
+			}
+		}
+	}
+	return g
+}
+
+// Unpack converts the grid back into a world of bytes, with 255 for alive cells and 0 for dead ones.
+func (g *BitGrid) Unpack() [][]byte {
+	world := make([][]byte, g.Height)
+	for y := range world {
+		world[y] = make([]byte, g.Width)
+		for x := range world[y] {
+			if g.Alive(x, y) {
+				world[y][x] = 255
+			}
+		}
+	}
+	return world
+}
+
+// Alive reports whether the cell at (x, y) is alive.
+func (g *BitGrid) Alive(x, y int) bool {
+	return g.Words[y*g.Stride+x/64]&(1<<uint(x%64)) != 0
+}
+
+// Set makes the cell at (x, y) alive or dead.
+func (g *BitGrid) Set(x, y int, alive bool) {
+	if alive {
+		g.Words[y*g.Stride+x/64] |= 1 << uint(x%64)
+	} else {
+		g.Words[y*g.Stride+x/64] &^= 1 << uint(x%64)
+	}
+}
+
+// Count returns the number of alive cells.
+func (g *BitGrid) Count() int {
+	count := 0
+	for _, word := range g.Words {
+		count += bits.OnesCount64(word)
+	}
+	return count
+}
+
+// AliveCells returns the coordinates of all alive cells.
+func (g *BitGrid) AliveCells() []util.Cell {
+	return g.setBits(g.Words)
+}
+
+// Flipped returns the coordinates of every cell that differs between g and other, which must be the same size.
+func (g *BitGrid) Flipped(other *BitGrid) []util.Cell {
+	diff := make([]uint64, len(g.Words))
+	for i := range diff {
+		diff[i] = g.Words[i] ^ other.Words[i]
+	}
+	return g.setBits(diff)
+}
+
+func (g *BitGrid) setBits(words []uint64) []util.Cell {
+	var cells []util.Cell
+	for i, word := range words {
+		for word != 0 {
+			bit := bits.TrailingZeros64(word)
+			cells = append(cells, util.Cell{X: (i%g.Stride)*64 + bit, Y: i / g.Stride})
+			word &= word - 1
+		}
+	}
+	return cells
+}
+
+// Rows returns a copy of rows [start, end) as a grid of their own.
+func (g *BitGrid) Rows(start, end int) *BitGrid {
+	strip := NewBitGrid(g.Width, end-start)
+	copy(strip.Words, g.Words[start*g.Stride:end*g.Stride])
+	return strip
+}
+
+// SetRows copies a strip produced by Rows back into the grid starting at row start.
+func (g *BitGrid) SetRows(start int, strip *BitGrid) {
+	copy(g.Words[start*g.Stride:], strip.Words)
+}
+
+// Packable reports whether the rule can be stepped on a BitGrid:
+// only two state rules over the 8 immediate neighbours are supported.
+func (r Rule) Packable() bool {
+	return !r.Generations() && !r.LargerThanLife()
+}
+
+// Step returns the next generation of the whole grid, splitting the rows between the given number of goroutines.
+// The rule must be Packable.
+func (g *BitGrid) Step(rule Rule, topology Topology, threads int) *BitGrid {
+	next := NewBitGrid(g.Width, g.Height)
+	g.stepParallel(next, rule, topology, 0, g.Height, threads)
+	return next
+}
+
+// stepParallel writes rows [start, end) of the next generation into next, splitting them between the threads.
+func (g *BitGrid) stepParallel(next *BitGrid, rule Rule, topology Topology, start, end, threads int) {
+	height := end - start
+	if threads <= 1 || threads > height {
+		g.StepRows(next, rule, topology, start, end)
+		return
+	}
+	done := make(chan bool)
+	for i := 0; i < threads; i++ {
+		a := start + i*(height/threads)
+		b := start + (i+1)*(height/threads)
+		if i == threads-1 {
+			b = end
+		}
+		go func(a, b int) {
+			g.StepRows(next, rule, topology, a, b)
+			done <- true
+		}(a, b)
+	}
+	for i := 0; i < threads; i++ {
+		<-done
+	}
+}
+
+// StepRows writes rows [start, end) of the next generation into next.
+// Each row is worked on 64 cells at a time: the 8 neighbour bitboards are summed
+// with full adders into a 4 bit count per cell, which is then matched against the rule.
+func (g *BitGrid) StepRows(next *BitGrid, rule Rule, topology Topology, start, end int) {
+	// each halo row holds the cell left of the row in bit 0, the row in bits 1 to Width and the cell right of it after
+	haloWords := (g.Width + 2 + 63) / 64
+	above := make([]uint64, haloWords+1)
+	middle := make([]uint64, haloWords+1)
+	below := make([]uint64, haloWords+1)
+	lastMask := ^uint64(0)
+	if g.Width%64 != 0 {
+		lastMask = 1<<uint(g.Width%64) - 1
+	}
+
+	g.haloRow(start-1, topology, above)
+	g.haloRow(start, topology, middle)
+	for y := start; y < end; y++ {
+		g.haloRow(y+1, topology, below)
+		for k := 0; k < g.Stride; k++ {
+			offset := 64 * k
+			aw, a, ae := extract(above, offset), extract(above, offset+1), extract(above, offset+2)
+			mw, m, me := extract(middle, offset), extract(middle, offset+1), extract(middle, offset+2)
+			bw, b, be := extract(below, offset), extract(below, offset+1), extract(below, offset+2)
+
+			// sum the 8 neighbours into ones + 2*twos + 4*fours + 8*eights
+			s1, c1 := fullAdd(aw, a, ae)
+			s2, c2 := fullAdd(bw, b, be)
+			s3, c3 := mw^me, mw&me
+			ones, c4 := fullAdd(s1, s2, s3)
+			t, c5 := fullAdd(c1, c2, c3)
+			twos, c6 := t^c4, t&c4
+			fours, eights := c5^c6, c5&c6
+
+			result := m&countIn(rule.Survival, ones, twos, fours, eights) | ^m&countIn(rule.Birth, ones, twos, fours, eights)
+			if k == g.Stride-1 {
+				result &= lastMask
+			}
+			next.Words[y*g.Stride+k] = result
+		}
+		above, middle, below = middle, below, above
+	}
+}
+
+// haloRow fills buf with the given row, which may be one row outside the grid, shifted up by one bit
+// and with the cells just beyond its left and right edges in the bits either side.
+func (g *BitGrid) haloRow(y int, topology Topology, buf []uint64) {
+	for i := range buf {
+		buf[i] = 0
+	}
+	setBit := func(pos int) {
+		buf[pos/64] |= 1 << uint(pos%64)
+	}
+
+	source, firstCol, ok := topology.Wrap(y, 0, g.Height, g.Width)
+	if ok {
+		if firstCol == 0 {
+			row := g.Words[source*g.Stride : (source+1)*g.Stride]
+			var carry uint64
+			for k, word := range row {
+				buf[k] = word<<1 | carry
+				carry = word >> 63
+			}
+			buf[g.Stride] |= carry
+		} else {
+			// the topology mirrors this row
+			for x := 0; x < g.Width; x++ {
+				if g.Alive(g.Width-1-x, source) {
+					setBit(x + 1)
+				}
+			}
+		}
+	}
+
+	if row, col, ok := topology.Wrap(y, -1, g.Height, g.Width); ok && g.Alive(col, row) {
+		setBit(0)
+	}
+	if row, col, ok := topology.Wrap(y, g.Width, g.Height, g.Width); ok && g.Alive(col, row) {
+		setBit(g.Width + 1)
+	}
+}
+
+// extract returns the 64 bits of buf starting at bit offset.
+func extract(buf []uint64, offset int) uint64 {
+	word, shift := offset/64, uint(offset%64)
+	if shift == 0 {
+		return buf[word]
+	}
+	return buf[word]>>shift | buf[word+1]<<(64-shift)
+}
+
+func fullAdd(a, b, c uint64) (sum, carry uint64) {
+	sum = a ^ b ^ c
+	carry = a&b | c&(a^b)
+	return sum, carry
+}
+
+// countIn returns the cells whose neighbour count, given as 4 bit-sliced bits, is one of the counts marked true.
+func countIn(counts []bool, ones, twos, fours, eights uint64) uint64 {
+	var match uint64
+	for n, ok := range counts {
+		if !ok {
+			continue
+		}
+		m := ^uint64(0)
+		for i, bit := range [4]uint64{ones, twos, fours, eights} {
+			if n&(1<<uint(i)) != 0 {
+				m &= bit
+			} else {
+				m &^= bit
+			}
+		}
+		match |= m
+	}
+	return match
+}
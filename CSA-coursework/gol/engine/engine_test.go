@@ -0,0 +1,93 @@
+package engine
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+// TestEngines tests that every engine steps any range of rows, and several turns at once, the same way as the rule.
+func TestEngines(t *testing.T) {
+	random := rand.New(rand.NewSource(4))
+	width, height := 70, 45
+	world := make([][]byte, height)
+	for y := range world {
+		world[y] = make([]byte, width)
+		for x := range world[y] {
+			if random.Intn(3) == 0 {
+				world[y][x] = 255
+			}
+		}
+	}
+	for _, rulestring := range []string{"B36/S23", "B2/S345/C4", "R2,C0,M1,S4..8,B5..6,NM"} {
+		rule := mustParseRule(t, rulestring)
+		topology := mustParseTopology(t, "klein")
+		expected := rule.NextRows(world, 0, height, topology)
+		for _, name := range []string{"default", "naive", "parallel", "packed"} {
+			stepper, err := New(name, rule, topology, 3)
+			if name == "packed" && !rule.Packable() {
+				if err == nil {
+					t.Errorf("ERROR: packed engine accepted %v", rulestring)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, rows := range [][2]int{{0, height}, {0, 1}, {10, 30}, {height - 2, height}} {
+				given := stepper.Step(world, rows[0], rows[1])
+				assertEqualWorld(t, fmt.Sprintf("%v %v rows %v", rulestring, stepper.Name(), rows), given, expected[rows[0]:rows[1]])
+			}
+			assertEqualWorld(t, fmt.Sprintf("%v %v 5 turns", rulestring, stepper.Name()),
+				stepper.StepN(world, 5), rule.NextRows(
+					rule.NextRows(rule.NextRows(rule.NextRows(expected, 0, height, topology), 0, height, topology), 0, height, topology),
+					0, height, topology))
+		}
+	}
+
+	if _, err := New("quantum", Rule{}, Torus, 1); err == nil {
+		t.Errorf("ERROR: expected an error for an unknown engine")
+	}
+}
+
+func mustParseRule(t *testing.T, rulestring string) Rule {
+	rule, err := ParseRule(rulestring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rule
+}
+
+func mustParseTopology(t *testing.T, name string) Topology {
+	topology, err := ParseTopology(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return topology
+}
+
+func assertEqualWorld(t *testing.T, name string, given, expected [][]byte) {
+	for y := range expected {
+		for x := range expected[y] {
+			if given[y][x] != expected[y][x] {
+				t.Errorf("ERROR: %v: cell (%v, %v) is %v, expected %v", name, x, y, given[y][x], expected[y][x])
+				return
+			}
+		}
+	}
+}
+
+// readWorld reads a width x height pgm image as a world.
+func readWorld(t *testing.T, path string, width, height int) [][]byte {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pixels := data[len(data)-width*height:]
+	world := make([][]byte, height)
+	for y := range world {
+		world[y] = pixels[y*width : (y+1)*width]
+	}
+	return world
+}
@@ -0,0 +1,284 @@
+package engine
+
+import (
+	"fmt"
+)
+
+// maxHashLifeNodes bounds the memoised nodes kept before the cache is rebuilt from the current world.
+const maxHashLifeNodes = 1 << 22
+
+// hlNode is a canonical quadtree node of size 2^level.
+// Nodes are hash-consed, so two nodes with the same contents are the same pointer.
+type hlNode struct {
+	nw, ne, sw, se *hlNode
+	level          int
+	population     int
+	// results[j] memoises the centre of the node advanced 2^j generations
+	results []*hlNode
+}
+
+// HashLife steps a torus with a memoised quadtree, so it can jump many generations at once.
+// The world is tiled into a square of size 2^level, which evolves exactly like the torus because
+// a periodic pattern stays periodic. Only Packable rules on a torus whose sides are powers of two are supported.
+type HashLife struct {
+	rule          Rule
+	width, height int
+	level         int
+	root          *hlNode
+	nodes         map[[4]*hlNode]*hlNode
+	leaves        [2]*hlNode
+	empty         []*hlNode
+	seen          map[*hlNode]int
+	turn          int
+}
+
+// NewHashLife builds the quadtree for a world. The world must be a torus with power of two sides,
+// and the rule must not give birth on 0 neighbours, as empty space is taken to stay empty.
+func NewHashLife(world [][]byte, rule Rule, topology Topology) (*HashLife, error) {
+	height := len(world)
+	width := len(world[0])
+	if !rule.Packable() {
+		return nil, fmt.Errorf("hashlife engine needs a two state rule over the 8 immediate neighbours, not %v", rule)
+	}
+	if rule.Birth[0] {
+		return nil, fmt.Errorf("hashlife engine can't step rules with birth on 0 neighbours, not %v", rule)
+	}
+	if topology != Torus {
+		return nil, fmt.Errorf("hashlife engine only supports a torus, not %v", topology)
+	}
+	if width&(width-1) != 0 || height&(height-1) != 0 {
+		return nil, fmt.Errorf("hashlife engine needs power of two sides, not %dx%d", width, height)
+	}
+
+	h := &HashLife{
+		rule:   rule,
+		width:  width,
+		height: height,
+		level:  2,
+		nodes:  make(map[[4]*hlNode]*hlNode),
+		seen:   make(map[*hlNode]int),
+	}
+	h.leaves[0] = &hlNode{}
+	h.leaves[1] = &hlNode{population: 1}
+	for 1<<uint(h.level) < width || 1<<uint(h.level) < height {
+		h.level++
+	}
+	h.root = h.build(world, 0, 0, h.level)
+	return h, nil
+}
+
+// build returns the node of the given level whose top-left cell is (x, y) of the tiled world.
+func (h *HashLife) build(world [][]byte, x, y, level int) *hlNode {
+	if level == 0 {
+		if world[y%h.height][x%h.width] == 255 {
+			return h.leaves[1]
+		}
+		return h.leaves[0]
+	}
+	half := 1 << uint(level-1)
+	return h.join(
+		h.build(world, x, y, level-1),
+		h.build(world, x+half, y, level-1),
+		h.build(world, x, y+half, level-1),
+		h.build(world, x+half, y+half, level-1),
+	)
+}
+
+// join returns the canonical node with the given quadrants.
+func (h *HashLife) join(nw, ne, sw, se *hlNode) *hlNode {
+	key := [4]*hlNode{nw, ne, sw, se}
+	if node, ok := h.nodes[key]; ok {
+		return node
+	}
+	node := &hlNode{
+		nw: nw, ne: ne, sw: sw, se: se,
+		level:      nw.level + 1,
+		population: nw.population + ne.population + sw.population + se.population,
+	}
+	h.nodes[key] = node
+	return node
+}
+
+// emptyNode returns the canonical dead node of the given level.
+func (h *HashLife) emptyNode(level int) *hlNode {
+	for len(h.empty) <= level {
+		if len(h.empty) == 0 {
+			h.empty = append(h.empty, h.leaves[0])
+			continue
+		}
+		e := h.empty[len(h.empty)-1]
+		h.empty = append(h.empty, h.join(e, e, e, e))
+	}
+	return h.empty[level]
+}
+
+// successor returns the centre half of a node advanced 2^j generations, where j <= level-2.
+func (h *HashLife) successor(m *hlNode, j int) *hlNode {
+	if m.population == 0 {
+		return h.emptyNode(m.level - 1)
+	}
+	if j > m.level-2 {
+		j = m.level - 2
+	}
+	if m.results == nil {
+		m.results = make([]*hlNode, m.level-1)
+	}
+	if result := m.results[j]; result != nil {
+		return result
+	}
+
+	var result *hlNode
+	if m.level == 2 {
+		result = h.base(m)
+	} else {
+		// the nine overlapping sub-squares of half the size, each advanced 2^j or 2^(level-3) generations
+		c1 := h.successor(m.nw, j)
+		c2 := h.successor(h.join(m.nw.ne, m.ne.nw, m.nw.se, m.ne.sw), j)
+		c3 := h.successor(m.ne, j)
+		c4 := h.successor(h.join(m.nw.sw, m.nw.se, m.sw.nw, m.sw.ne), j)
+		c5 := h.successor(h.join(m.nw.se, m.ne.sw, m.sw.ne, m.se.nw), j)
+		c6 := h.successor(h.join(m.ne.sw, m.ne.se, m.se.nw, m.se.ne), j)
+		c7 := h.successor(m.sw, j)
+		c8 := h.successor(h.join(m.sw.ne, m.se.nw, m.sw.se, m.se.sw), j)
+		c9 := h.successor(m.se, j)
+		if j < m.level-2 {
+			// the sub-squares already advanced far enough, so just take their centres
+			result = h.join(
+				h.join(c1.se, c2.sw, c4.ne, c5.nw),
+				h.join(c2.se, c3.sw, c5.ne, c6.nw),
+				h.join(c4.se, c5.sw, c7.ne, c8.nw),
+				h.join(c5.se, c6.sw, c8.ne, c9.nw),
+			)
+		} else {
+			result = h.join(
+				h.successor(h.join(c1, c2, c4, c5), j),
+				h.successor(h.join(c2, c3, c5, c6), j),
+				h.successor(h.join(c4, c5, c7, c8), j),
+				h.successor(h.join(c5, c6, c8, c9), j),
+			)
+		}
+	}
+	m.results[j] = result
+	return result
+}
+
+// base advances the centre 2x2 of a 4x4 node by one generation.
+func (h *HashLife) base(m *hlNode) *hlNode {
+	var cells [4][4]int
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			cells[y][x] = m.cell(x, y)
+		}
+	}
+	var next [4]*hlNode
+	for i, centre := range [4][2]int{{1, 1}, {2, 1}, {1, 2}, {2, 2}} {
+		x, y := centre[0], centre[1]
+		alive := 0
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+				if dx != 0 || dy != 0 {
+					alive += cells[y+dy][x+dx]
+				}
+			}
+		}
+		var cell byte
+		if cells[y][x] == 1 {
+			cell = 255
+		}
+		next[i] = h.leaves[0]
+		if h.rule.Next(cell, alive) == 255 {
+			next[i] = h.leaves[1]
+		}
+	}
+	return h.join(next[0], next[1], next[2], next[3])
+}
+
+// cell returns 1 if the cell at (x, y) within the node is alive.
+func (m *hlNode) cell(x, y int) int {
+	for m.level > 0 {
+		half := 1 << uint(m.level-1)
+		switch {
+		case x < half && y < half:
+			m = m.nw
+		case y < half:
+			m, x = m.ne, x-half
+		case x < half:
+			m, y = m.sw, y-half
+		default:
+			m, x, y = m.se, x-half, y-half
+		}
+	}
+	return m.population
+}
+
+// Advance moves the world forward by at most limit turns and returns how many turns it advanced.
+// Each call makes the biggest jump it can: a power of two no larger than half the tiled square,
+// or, once the world is found to repeat, a whole number of periods.
+func (h *HashLife) Advance(limit int) int {
+	if limit <= 0 {
+		return 0
+	}
+	if len(h.nodes) > maxHashLifeNodes {
+		h.reset()
+	}
+	maxJump := h.level - 1
+	if limit >= 1<<uint(maxJump) {
+		if seenTurn, ok := h.seen[h.root]; ok && seenTurn < h.turn {
+			period := h.turn - seenTurn
+			if skip := (limit / period) * period; skip > 0 {
+				h.turn += skip
+				return skip
+			}
+		}
+		h.seen[h.root] = h.turn
+	}
+	j := 0
+	for j < maxJump && 1<<uint(j+1) <= limit {
+		j++
+	}
+
+	// the tiled square sits at the centre of 4 copies of itself; the centre of the result is
+	// the torus advanced 2^j generations and shifted by half its size, so the quadrants are swapped back
+	r := h.successor(h.join(h.root, h.root, h.root, h.root), j)
+	h.root = h.join(r.se, r.sw, r.ne, r.nw)
+	h.turn += 1 << uint(j)
+	return 1 << uint(j)
+}
+
+// reset drops every memoised node and rebuilds the quadtree for the current world.
+func (h *HashLife) reset() {
+	world := h.Grid().Unpack()
+	h.nodes = make(map[[4]*hlNode]*hlNode)
+	h.seen = make(map[*hlNode]int)
+	h.empty = nil
+	h.root = h.build(world, 0, 0, h.level)
+}
+
+// Count returns the number of alive cells in the world.
+func (h *HashLife) Count() int {
+	tiles := (1 << uint(h.level)) / h.width * ((1 << uint(h.level)) / h.height)
+	return h.root.population / tiles
+}
+
+// Grid returns the current world as a BitGrid.
+func (h *HashLife) Grid() *BitGrid {
+	grid := NewBitGrid(h.width, h.height)
+	h.fill(grid, h.root, 0, 0)
+	return grid
+}
+
+// fill sets the alive cells of the node with top-left cell (x, y) that lie in the first tile.
+func (h *HashLife) fill(grid *BitGrid, m *hlNode, x, y int) {
+	if m.population == 0 || x >= h.width || y >= h.height {
+		return
+	}
+	if m.level == 0 {
+		grid.Set(x, y, true)
+		return
+	}
+	half := 1 << uint(m.level-1)
+	h.fill(grid, m.nw, x, y)
+	h.fill(grid, m.ne, x+half, y)
+	h.fill(grid, m.sw, x, y+half)
+	h.fill(grid, m.se, x+half, y+half)
+}
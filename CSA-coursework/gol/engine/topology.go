@@ -0,0 +1,104 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Topology describes how the edges of the world are glued together.
+type Topology int
+
+const (
+	Torus        Topology = iota // both axes wrap around
+	Plane                        // cells beyond the edges are always dead
+	KleinBottle                  // columns wrap, rows wrap with the columns mirrored
+	CrossSurface                 // both axes wrap with the other axis mirrored (the real projective plane)
+	CylinderX                    // columns wrap, cells above and below the world are dead
+	CylinderY                    // rows wrap, cells left and right of the world are dead
+)
+
+var topologyNames = map[Topology]string{
+	Torus:        "torus",
+	Plane:        "plane",
+	KleinBottle:  "klein",
+	CrossSurface: "cross",
+	CylinderX:    "cylinder-x",
+	CylinderY:    "cylinder-y",
+}
+
+// ParseTopology parses a topology name such as "torus", "plane", "klein", "cross", "cylinder-x" or "cylinder-y".
+// An empty string is treated as "torus".
+func ParseTopology(s string) (Topology, error) {
+	name := strings.ToLower(strings.TrimSpace(s))
+	if name == "" {
+		return Torus, nil
+	}
+	for topology, topologyName := range topologyNames {
+		if name == topologyName {
+			return topology, nil
+		}
+	}
+	return Torus, fmt.Errorf("invalid topology %q: expected one of torus, plane, klein, cross, cylinder-x or cylinder-y", s)
+}
+
+func (t Topology) String() string {
+	if name, ok := topologyNames[t]; ok {
+		return name
+	}
+	return "Incorrect Topology"
+}
+
+// Wrap maps a position outside a height x width world back onto the world, however many world sizes
+// outside it lies, as Larger than Life neighbourhoods can be wider than the world.
+// It returns false if the position is beyond a dead edge.
+func (t Topology) Wrap(row, col, height, width int) (int, int, bool) {
+	// Each pass crosses one edge, so on the mirrored topologies every crossing flips the other axis
+	for col < 0 || col >= width || row < 0 || row >= height {
+		if col < 0 || col >= width {
+			switch t {
+			case Plane, CylinderY:
+				return 0, 0, false
+			case CrossSurface:
+				row = height - 1 - row
+			}
+			if col < 0 {
+				col += width
+			} else {
+				col -= width
+			}
+		}
+		if row < 0 || row >= height {
+			switch t {
+			case Plane, CylinderX:
+				return 0, 0, false
+			case KleinBottle, CrossSurface:
+				col = width - 1 - col
+			}
+			if row < 0 {
+				row += height
+			} else {
+				row -= height
+			}
+		}
+	}
+	return row, col, true
+}
+
+// Pad returns rows [start-halo, end+halo) of the world with halo extra columns on either side,
+// filled in according to the topology. Stepping the inner cells of the padded rows needs no
+// further knowledge of the topology, so the result can be handed to a worker as a self-contained segment.
+func (t Topology) Pad(world [][]byte, start, end, halo int) [][]byte {
+	height := len(world)
+	width := len(world[0])
+	padded := make([][]byte, end-start+2*halo)
+	for i := range padded {
+		padded[i] = make([]byte, width+2*halo)
+		for j := range padded[i] {
+			row, col, ok := t.Wrap(start-halo+i, j-halo, height, width)
+			if ok {
+				padded[i][j] = world[row][col]
+			}
+		}
+	}
+	return padded
+}
@@ -0,0 +1,111 @@
+package engine
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// TestTopology tests 16x16 and 64x64 images on 1 and 100 turns for every topology against the check images,
+// both with the 8 neighbour counting and with Life expressed as a Larger than Life rule.
+func TestTopology(t *testing.T) {
+	for _, name := range []string{"torus", "plane", "klein", "cross", "cylinder-x", "cylinder-y"} {
+		topology := mustParseTopology(t, name)
+		for _, size := range []int{16, 64} {
+			for _, rulestring := range []string{"B3/S23", "R1,C0,M1,S3..4,B3,NM"} {
+				rule := mustParseRule(t, rulestring)
+				world := readWorld(t, fmt.Sprintf("../../images/%vx%v.pgm", size, size), size, size)
+				for turn := 1; turn <= 100; turn++ {
+					world = rule.NextRows(world, 0, size, topology)
+					if turn == 1 || turn == 100 {
+						expected := readWorld(t, fmt.Sprintf("../../check/topology/%v/%vx%vx%v.pgm", name, size, size, turn), size, size)
+						assertEqualWorld(t, fmt.Sprintf("%v %v %vx%vx%v", name, rulestring, size, size, turn), world, expected)
+					}
+				}
+			}
+		}
+	}
+}
+
+// TestSmallWorld tests that positions several world sizes outside a small world wrap the same way as tiling the plane
+// with copies of the world, and that a Larger than Life neighbourhood wider than the world counts its cells that way.
+func TestSmallWorld(t *testing.T) {
+	const width, height = 5, 4
+	random := rand.New(rand.NewSource(5))
+	world := make([][]byte, height)
+	for y := range world {
+		world[y] = make([]byte, width)
+		for x := range world[y] {
+			if random.Intn(2) == 0 {
+				world[y][x] = 255
+			}
+		}
+	}
+	rule := mustParseRule(t, "R7,C0,M1,S20..60,B30..50,NM")
+	for _, name := range []string{"torus", "plane", "klein", "cross", "cylinder-x", "cylinder-y"} {
+		topology := mustParseTopology(t, name)
+		for row := -3 * height; row < 4*height; row++ {
+			for col := -3 * width; col < 4*width; col++ {
+				expectedRow, expectedCol, expectedOk := tile(topology, row, col, height, width)
+				givenRow, givenCol, givenOk := topology.Wrap(row, col, height, width)
+				if givenOk != expectedOk || givenOk && (givenRow != expectedRow || givenCol != expectedCol) {
+					t.Fatalf("ERROR: %v wrapped (%v, %v) to (%v, %v, %v), expected (%v, %v, %v)",
+						name, col, row, givenCol, givenRow, givenOk, expectedCol, expectedRow, expectedOk)
+				}
+			}
+		}
+
+		next := rule.NextRows(world, 0, height, topology)
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				alive := 0
+				for dy := -rule.Radius; dy <= rule.Radius; dy++ {
+					for dx := -rule.Radius; dx <= rule.Radius; dx++ {
+						if row, col, ok := tile(topology, y+dy, x+dx, height, width); ok && world[row][col] == 255 {
+							alive++
+						}
+					}
+				}
+				if expected := rule.Next(world[y][x], alive); next[y][x] != expected {
+					t.Fatalf("ERROR: %v at (%v, %v) expected %v, got %v", name, x, y, expected, next[y][x])
+				}
+			}
+		}
+	}
+}
+
+// tile finds which cell of the world a position lies on when the plane is tiled with copies of it.
+// Copies an odd number of rows of copies away are mirrored left to right on the Klein bottle and the cross surface,
+// and copies an odd number of columns of copies away are mirrored top to bottom on the cross surface.
+func tile(topology Topology, row, col, height, width int) (int, int, bool) {
+	tileRow, tileCol := floorDiv(row, height), floorDiv(col, width)
+	row, col = row-tileRow*height, col-tileCol*width
+	switch topology {
+	case Plane:
+		if tileRow != 0 || tileCol != 0 {
+			return 0, 0, false
+		}
+	case CylinderX:
+		if tileRow != 0 {
+			return 0, 0, false
+		}
+	case CylinderY:
+		if tileCol != 0 {
+			return 0, 0, false
+		}
+	}
+	if (topology == KleinBottle || topology == CrossSurface) && tileRow%2 != 0 {
+		col = width - 1 - col
+	}
+	if topology == CrossSurface && tileCol%2 != 0 {
+		row = height - 1 - row
+	}
+	return row, col, true
+}
+
+func floorDiv(a, b int) int {
+	if a < 0 {
+		return -((-a + b - 1) / b)
+	}
+	return a / b
+}
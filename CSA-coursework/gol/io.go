@@ -3,8 +3,9 @@ package gol
 import (
 	"fmt"
 	"os"
-	"strconv"
+	"path/filepath"
 	"strings"
+	"uk.ac.bris.cs/gameoflife/gol/engine"
 	"uk.ac.bris.cs/gameoflife/util"
 )
 
@@ -15,6 +16,9 @@ type ioChannels struct {
 	filename <-chan string
 	output   <-chan uint8
 	input    chan<- uint8
+	err      chan<- error
+	size     chan<- int
+	turn     <-chan int
 }
 
 // ioState is the internal ioState of the io goroutine.
@@ -37,26 +41,158 @@ const (
 	ioCheckIdle
 )
 
-// writePgmImage receives an array of bytes and writes it to a pgm file.
-func (io *ioState) writePgmImage() {
-	_ = os.Mkdir("out", os.ModePerm)
-
+// writeImage receives the completed turns and an array of bytes, and writes it to the output directory
+// in the format given by the extension of the filename, or by Params.Format if it has none.
+// PNG images with the heat palette are followed by the age of every cell, also as an array of bytes.
+// The whole world is always received, so a file that can't be written doesn't leave the distributor blocked,
+// and then the result of the write is sent back to the distributor.
+func (io *ioState) writeImage() {
 	// Request a filename from the distributor.
 	filename := <-io.channels.filename
+	format := formatOf(filename, io.params.Format)
+	turn := <-io.channels.turn
+	world := io.receiveWorld()
+	var ages [][]byte
+	if sendsAges(io.params, format) {
+		ages = io.receiveWorld()
+	}
 
-	file, ioError := os.Create("out/" + filename + ".pgm")
-	util.Check(ioError)
+	if io.params.Stream {
+		// nothing else may be printed to stdout, which is the image
+		io.channels.err <- io.streamImage(os.Stdout, format, world)
+		return
+	}
+	path := outputPath(io.params, filename)
+	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err == nil {
+		switch format {
+		case "rle":
+			err = io.writeRleImage(path, world)
+		case "cells":
+			err = io.writeCellsImage(path, world)
+		case "lif":
+			err = io.writeLife106Image(path, world)
+		case "png":
+			err = io.writePngImage(path, world, ages)
+		case "golc":
+			err = io.writeCheckpointImage(path, world, turn)
+		default:
+			err = io.writePgmImage(path, world)
+		}
+	}
+	if err == nil {
+		fmt.Println("File", filename, "output done!")
+	}
+	io.channels.err <- err
+}
+
+// writePgmImage writes an array of bytes to a pgm file.
+func (io *ioState) writePgmImage(path string, world [][]byte) error {
+	file, ioError := os.Create(path)
+	if ioError != nil {
+		return ioError
+	}
 	defer file.Close()
 
-	_, _ = file.WriteString("P5\n")
-	//_, _ = file.WriteString("# PGM file writer by pnmmodules (https://github.com/owainkenwayucl/pnmmodules).\n")
-	_, _ = file.WriteString(strconv.Itoa(io.params.ImageWidth))
-	_, _ = file.WriteString(" ")
-	_, _ = file.WriteString(strconv.Itoa(io.params.ImageHeight))
-	_, _ = file.WriteString("\n")
-	_, _ = file.WriteString(strconv.Itoa(255))
-	_, _ = file.WriteString("\n")
+	if err := writePgm(file, world); err != nil {
+		return err
+	}
+	return file.Sync()
+}
 
+// writeRleImage writes an array of bytes to an rle file.
+func (io *ioState) writeRleImage(path string, world [][]byte) error {
+	rule, err := engine.ParseRule(io.params.Rule)
+	if err != nil {
+		return err
+	}
+
+	file, ioError := os.Create(path)
+	if ioError != nil {
+		return ioError
+	}
+	defer file.Close()
+
+	if err := writeRle(file, world, rule); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// writeCellsImage writes an array of bytes to a plaintext cells file.
+func (io *ioState) writeCellsImage(path string, world [][]byte) error {
+	file, ioError := os.Create(path)
+	if ioError != nil {
+		return ioError
+	}
+	defer file.Close()
+
+	if err := writeCells(file, world); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// writeLife106Image writes the alive cells of an array of bytes to a Life 1.06 file.
+func (io *ioState) writeLife106Image(path string, world [][]byte) error {
+	file, ioError := os.Create(path)
+	if ioError != nil {
+		return ioError
+	}
+	defer file.Close()
+
+	var cells []util.Cell
+	for y, row := range world {
+		for x, cell := range row {
+			if cell == 255 {
+				cells = append(cells, util.Cell{X: x, Y: y})
+			}
+		}
+	}
+	if err := writeLife106(file, cells); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// writePngImage writes an array of bytes to a png file, coloured by Params.Palette and scaled by Params.Scale.
+func (io *ioState) writePngImage(path string, world, ages [][]byte) error {
+	file, ioError := os.Create(path)
+	if ioError != nil {
+		return ioError
+	}
+	defer file.Close()
+
+	if err := writePng(file, world, ages, io.params.Palette, io.params.Scale); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// writeCheckpointImage writes an array of bytes and everything needed to carry on from it to a golc file.
+func (io *ioState) writeCheckpointImage(path string, world [][]byte, turn int) error {
+	file, ioError := os.Create(path)
+	if ioError != nil {
+		return ioError
+	}
+	defer file.Close()
+
+	header := checkpointHeader{
+		Turn:     turn,
+		Turns:    io.params.Turns,
+		Rule:     io.params.Rule,
+		Topology: io.params.Topology,
+		Engine:   io.params.Engine,
+		Seed:     io.params.Seed,
+	}
+	if err := writeCheckpoint(file, world, header); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// receiveWorld receives a whole world from the distributor, a byte at a time.
+func (io *ioState) receiveWorld() [][]byte {
 	world := make([][]byte, io.params.ImageHeight)
 	for i := range world {
 		world[i] = make([]byte, io.params.ImageWidth)
@@ -64,64 +200,170 @@ func (io *ioState) writePgmImage() {
 
 	for y := 0; y < io.params.ImageHeight; y++ {
 		for x := 0; x < io.params.ImageWidth; x++ {
-			val := <-io.channels.output
-			//if val != 0 {
-			//	fmt.Println(x, y)
-			//}
-			world[y][x] = val
+			world[y][x] = <-io.channels.output
 		}
 	}
+	return world
+}
 
-	for y := 0; y < io.params.ImageHeight; y++ {
-		for x := 0; x < io.params.ImageWidth; x++ {
-			_, ioError = file.Write([]byte{world[y][x]})
-			util.Check(ioError)
-		}
+// readImage reads the world named by the distributor and sends the result back on the error channel.
+// Only if that was nil does it go on to send the width and height of the world, then the world as an array of bytes.
+// Names ending in .rle, .cells, .lif, .life, .pbm, .pgm or .pnm are paths to pattern files,
+// .golc to checkpoints, and anything else is <name>.pgm in the input directory.
+// If Params.ImageWidth or Params.ImageHeight is 0, the size of the world is taken from the file,
+// and every image written afterwards has that size.
+func (io *ioState) readImage() {
+
+	// Request a filename from the distributor.
+	filename := <-io.channels.filename
+
+	var world [][]byte
+	var err error
+	ext := strings.ToLower(filepath.Ext(filename))
+	switch {
+	case io.params.Soup > 0:
+		// the filename only describes the soup
+		world, err = randomSoup(io.params)
+	case io.params.Stream:
+		world, err = io.readPatternFrom(filename, os.Stdin, parseStream)
+	case ext == ".rle":
+		world, err = io.readPattern(filename, parseRle)
+	case ext == ".cells":
+		world, err = io.readPattern(filename, parseCells)
+	case ext == ".lif" || ext == ".life":
+		world, err = io.readLife106(filename)
+	case ext == ".pbm" || ext == ".pgm" || ext == ".pnm":
+		world, err = io.readPattern(filename, parsePnm)
+	case ext == ".golc":
+		world, err = io.readCheckpointImage(filename)
+	default:
+		world, err = io.readPgmImage(filename)
+	}
+	if err == nil && (len(world) == 0 || len(world[0]) == 0) {
+		err = fmt.Errorf("%v: no cells to size the world by", filename)
+	}
+	io.channels.err <- err
+	if err != nil {
+		return
 	}
 
-	ioError = file.Sync()
-	util.Check(ioError)
+	io.params.ImageWidth, io.params.ImageHeight = len(world[0]), len(world)
+	io.channels.size <- io.params.ImageWidth
+	io.channels.size <- io.params.ImageHeight
 
-	fmt.Println("File", filename, "output done!")
+	for _, row := range world {
+		for _, b := range row {
+			io.channels.input <- b
+		}
+	}
+
+	if !io.params.Stream {
+		fmt.Println("File", filename, "input done!")
+	}
 }
 
-// readPgmImage opens a pgm file and sends its data as an array of bytes.
-func (io *ioState) readPgmImage() {
+// readPattern opens a pattern file and returns the pattern placed in the world.
+func (io *ioState) readPattern(path string, parse patternParser) ([][]byte, error) {
+	file, ioError := os.Open(path)
+	if ioError != nil {
+		return nil, ioError
+	}
+	defer file.Close()
 
-	// Request a filename from the distributor.
-	filename := <-io.channels.filename
+	return io.readPatternFrom(path, file, parse)
+}
 
-	data, ioError := os.ReadFile("images/" + filename + ".pgm")
-	util.Check(ioError)
+// readPatternFrom parses a pattern from an open file, named name in errors, and returns it placed in the world.
+func (io *ioState) readPatternFrom(name string, file *os.File, parse patternParser) ([][]byte, error) {
+	rule, err := engine.ParseRule(io.params.Rule)
+	if err != nil {
+		return nil, err
+	}
 
-	fields := strings.Fields(string(data))
+	pattern, err := parse(file, rule)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", name, err)
+	}
+	width, height := io.params.ImageWidth, io.params.ImageHeight
+	if width == 0 || height == 0 {
+		width, height = patternSize(pattern, io.params.PatternOffset)
+	}
+	return placePattern(pattern, width, height, io.params.PatternOffset)
+}
 
-	if fields[0] != "P5" {
-		panic("Not a pgm file")
+// readLife106 opens a Life 1.06 file and returns its cells placed in the world.
+func (io *ioState) readLife106(path string) ([][]byte, error) {
+	file, ioError := os.Open(path)
+	if ioError != nil {
+		return nil, ioError
 	}
+	defer file.Close()
 
-	width, _ := strconv.Atoi(fields[1])
-	if width != io.params.ImageWidth {
-		panic("Incorrect width")
+	cells, err := parseLife106(file)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	width, height := io.params.ImageWidth, io.params.ImageHeight
+	offset := io.params.PatternOffset
+	if width == 0 || height == 0 {
+		var origin util.Cell
+		origin, width, height = cellsSize(cells, offset)
+		offset = &origin
 	}
+	return placeCells(cells, width, height, offset)
+}
 
-	height, _ := strconv.Atoi(fields[2])
-	if height != io.params.ImageHeight {
-		panic("Incorrect height")
+// readCheckpointImage opens a checkpoint, which must be the size of the world, and returns its cells.
+// Run has already taken the rest of the header into Params.
+func (io *ioState) readCheckpointImage(path string) ([][]byte, error) {
+	file, ioError := os.Open(path)
+	if ioError != nil {
+		return nil, ioError
 	}
+	defer file.Close()
 
-	maxval, _ := strconv.Atoi(fields[3])
-	if maxval != 255 {
-		panic("Incorrect maxval/bit depth")
+	header, world, err := readCheckpoint(file)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	if header.Width != io.params.ImageWidth || header.Height != io.params.ImageHeight {
+		return nil, fmt.Errorf("%v is %dx%d, expected %dx%d",
+			path, header.Width, header.Height, io.params.ImageWidth, io.params.ImageHeight)
 	}
+	return world, nil
+}
 
-	image := []byte(fields[4])
+// readPgmImage opens <filename>.pgm in the input directory, which must be the size of the world if that is given,
+// and returns its data.
+func (io *ioState) readPgmImage(filename string) ([][]byte, error) {
+	rule, err := engine.ParseRule(io.params.Rule)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, b := range image {
-		io.channels.input <- b
+	dir := io.params.InputDir
+	if dir == "" {
+		dir = "images"
 	}
+	path := filepath.Join(dir, filename+".pgm")
+	file, ioError := os.Open(path)
+	if ioError != nil {
+		return nil, ioError
+	}
+	defer file.Close()
 
-	fmt.Println("File", filename, "input done!")
+	world, err := parsePnm(file, rule)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	if io.params.ImageWidth == 0 || io.params.ImageHeight == 0 {
+		return world, nil
+	}
+	if len(world) != io.params.ImageHeight || len(world[0]) != io.params.ImageWidth {
+		return nil, fmt.Errorf("%v is %dx%d, expected %dx%d",
+			path, len(world[0]), len(world), io.params.ImageWidth, io.params.ImageHeight)
+	}
+	return world, nil
 }
 
 // startIo should be the entrypoint of the io goroutine.
@@ -135,9 +377,9 @@ func startIo(p Params, c ioChannels) {
 		// Block and wait for requests from the distributor
 		switch command {
 		case ioInput:
-			io.readPgmImage()
+			io.readImage()
 		case ioOutput:
-			io.writePgmImage()
+			io.writeImage()
 		case ioCheckIdle:
 			io.channels.idle <- true
 		}
@@ -0,0 +1,224 @@
+package gol
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"uk.ac.bris.cs/gameoflife/gol/engine"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// patternParser reads a pattern file into its bounding box of cells, as grey levels.
+type patternParser func(r io.Reader, rule engine.Rule) ([][]byte, error)
+
+// parseCells reads a pattern in plaintext format, e.g.
+//
+//	!Name: Glider
+//	.O.
+//	..O
+//	OOO
+//
+// where lines starting with ! are comments, O (or *) is alive and . is dead.
+// Rows shorter than the longest one are padded with dead cells.
+func parseCells(r io.Reader, _ engine.Rule) ([][]byte, error) {
+	scanner := bufio.NewScanner(r)
+	var pattern [][]byte
+	width := 0
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if strings.HasPrefix(line, "!") {
+			continue
+		}
+		row := make([]byte, len(line))
+		for x, c := range []byte(line) {
+			switch c {
+			case 'O', '*':
+				row[x] = 255
+			case '.':
+			default:
+				return nil, fmt.Errorf("cells: unexpected %q on row %d", c, len(pattern)+1)
+			}
+		}
+		if len(row) > width {
+			width = len(row)
+		}
+		pattern = append(pattern, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for y, row := range pattern {
+		if len(row) < width {
+			pattern[y] = append(row, make([]byte, width-len(row))...)
+		}
+	}
+	return pattern, nil
+}
+
+// writeCells writes a world in plaintext format. Only fully alive cells are written as alive.
+func writeCells(w io.Writer, world [][]byte) error {
+	out := bufio.NewWriter(w)
+	for _, row := range world {
+		line := make([]byte, len(row))
+		for x, cell := range row {
+			line[x] = '.'
+			if cell == 255 {
+				line[x] = 'O'
+			}
+		}
+		out.Write(line)
+		out.WriteByte('\n')
+	}
+	return out.Flush()
+}
+
+// parseLife106 reads a pattern in Life 1.06 format: a "#Life 1.06" header followed by
+// one "x y" line per alive cell. Coordinates are relative to an origin and may be negative.
+func parseLife106(r io.Reader) ([]util.Cell, error) {
+	scanner := bufio.NewScanner(r)
+	var cells []util.Cell
+	header := false
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !header {
+			if line != "#Life 1.06" {
+				return nil, fmt.Errorf("life 1.06: missing #Life 1.06 header")
+			}
+			header = true
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("life 1.06: malformed line %q", line)
+		}
+		x, errX := strconv.Atoi(fields[0])
+		y, errY := strconv.Atoi(fields[1])
+		if errX != nil || errY != nil {
+			return nil, fmt.Errorf("life 1.06: malformed line %q", line)
+		}
+		cells = append(cells, util.Cell{X: x, Y: y})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !header {
+		return nil, fmt.Errorf("life 1.06: missing #Life 1.06 header")
+	}
+	return cells, nil
+}
+
+// writeLife106 writes alive cells in Life 1.06 format, so a sparse list such as FinalTurnComplete.Alive
+// can be saved without building a world. The cells are written in row order.
+func writeLife106(w io.Writer, cells []util.Cell) error {
+	sorted := append([]util.Cell(nil), cells...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Y != sorted[j].Y {
+			return sorted[i].Y < sorted[j].Y
+		}
+		return sorted[i].X < sorted[j].X
+	})
+	out := bufio.NewWriter(w)
+	fmt.Fprintln(out, "#Life 1.06")
+	for _, cell := range sorted {
+		fmt.Fprintf(out, "%d %d\n", cell.X, cell.Y)
+	}
+	return out.Flush()
+}
+
+// placePattern returns a width x height world with the pattern's top left corner at offset,
+// or with the pattern centred if offset is nil.
+func placePattern(pattern [][]byte, width, height int, offset *util.Cell) ([][]byte, error) {
+	patternHeight := len(pattern)
+	patternWidth := 0
+	if patternHeight > 0 {
+		patternWidth = len(pattern[0])
+	}
+	left, top := (width-patternWidth)/2, (height-patternHeight)/2
+	if offset != nil {
+		left, top = offset.X, offset.Y
+	}
+	if left < 0 || top < 0 || left+patternWidth > width || top+patternHeight > height {
+		return nil, fmt.Errorf("a %dx%d pattern at (%d, %d) doesn't fit in a %dx%d world",
+			patternWidth, patternHeight, left, top, width, height)
+	}
+	world := make([][]byte, height)
+	for y := range world {
+		world[y] = make([]byte, width)
+	}
+	for y, row := range pattern {
+		copy(world[top+y][left:], row)
+	}
+	return world, nil
+}
+
+// placeCells returns a width x height world with the given cells alive, with the origin of their
+// coordinates at offset, or at the centre of the world if offset is nil.
+func placeCells(cells []util.Cell, width, height int, offset *util.Cell) ([][]byte, error) {
+	originX, originY := width/2, height/2
+	if offset != nil {
+		originX, originY = offset.X, offset.Y
+	}
+	world := make([][]byte, height)
+	for y := range world {
+		world[y] = make([]byte, width)
+	}
+	for _, cell := range cells {
+		x, y := originX+cell.X, originY+cell.Y
+		if x < 0 || y < 0 || x >= width || y >= height {
+			return nil, fmt.Errorf("cell (%d, %d) with the origin at (%d, %d) doesn't fit in a %dx%d world",
+				cell.X, cell.Y, originX, originY, width, height)
+		}
+		world[y][x] = 255
+	}
+	return world, nil
+}
+
+// patternSize returns the size of the smallest world that holds the pattern with its top left corner at offset.
+// A nil offset puts the pattern in the corner, where it is also centred.
+func patternSize(pattern [][]byte, offset *util.Cell) (int, int) {
+	height := len(pattern)
+	width := 0
+	if height > 0 {
+		width = len(pattern[0])
+	}
+	if offset != nil {
+		width += offset.X
+		height += offset.Y
+	}
+	return width, height
+}
+
+// cellsSize returns the size of the smallest world that holds the cells with their origin at offset,
+// and where that origin is. A nil offset moves the top left of the cells' bounding box to the corner.
+func cellsSize(cells []util.Cell, offset *util.Cell) (util.Cell, int, int) {
+	if len(cells) == 0 {
+		return util.Cell{}, 0, 0
+	}
+	min, max := cells[0], cells[0]
+	for _, cell := range cells {
+		if cell.X < min.X {
+			min.X = cell.X
+		}
+		if cell.Y < min.Y {
+			min.Y = cell.Y
+		}
+		if cell.X > max.X {
+			max.X = cell.X
+		}
+		if cell.Y > max.Y {
+			max.Y = cell.Y
+		}
+	}
+	origin := util.Cell{X: -min.X, Y: -min.Y}
+	if offset != nil {
+		origin = *offset
+	}
+	return origin, origin.X + max.X + 1, origin.Y + max.Y + 1
+}
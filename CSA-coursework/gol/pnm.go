@@ -0,0 +1,213 @@
+package gol
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+
+	"uk.ac.bris.cs/gameoflife/gol/engine"
+)
+
+// parsePnm streams a netpbm image in any of the P1 and P4 (bitmap) or P2 and P5 (greymap) formats,
+// allowing # comments anywhere in the header, and returns its cells.
+// Bitmap pixels are alive when black (1). Greymap samples are scaled from 0..maxval to 0..255;
+// under a two state rule they are then thresholded, so anything at least half way to white is alive,
+// while a Generations rule keeps the grey levels as dying states.
+func parsePnm(r io.Reader, rule engine.Rule) ([][]byte, error) {
+	in := bufio.NewReader(r)
+	magic, err := pnmToken(in)
+	if err != nil {
+		return nil, fmt.Errorf("pnm: %v", err)
+	}
+	switch magic {
+	case "P1", "P2", "P4", "P5":
+	default:
+		return nil, fmt.Errorf("pnm: unsupported magic number %q", magic)
+	}
+	bitmap := magic == "P1" || magic == "P4"
+	ascii := magic == "P1" || magic == "P2"
+
+	width, err := pnmNumber(in, "width")
+	if err != nil {
+		return nil, err
+	}
+	height, err := pnmNumber(in, "height")
+	if err != nil {
+		return nil, err
+	}
+	maxval := 1
+	if !bitmap {
+		if maxval, err = pnmNumber(in, "maxval"); err != nil {
+			return nil, err
+		}
+		if maxval < 1 || maxval > 65535 {
+			return nil, fmt.Errorf("pnm: maxval %d out of range", maxval)
+		}
+	}
+	if width < 1 || height < 1 {
+		return nil, fmt.Errorf("pnm: bad size %dx%d", width, height)
+	}
+
+	// toCell maps a sample to the value stored in the world
+	toCell := func(sample int) byte {
+		if bitmap {
+			if sample != 0 {
+				return 255
+			}
+			return 0
+		}
+		if sample > maxval {
+			sample = maxval
+		}
+		grey := byte(sample * 255 / maxval)
+		if rule.Generations() {
+			return grey
+		}
+		if grey >= 128 {
+			return 255
+		}
+		return 0
+	}
+
+	world := make([][]byte, height)
+	for y := range world {
+		world[y] = make([]byte, width)
+	}
+	switch {
+	case ascii:
+		for y := range world {
+			for x := range world[y] {
+				var sample int
+				if bitmap {
+					// P1 samples don't need separating whitespace
+					sample, err = pbmDigit(in)
+				} else {
+					sample, err = pnmNumber(in, "sample")
+				}
+				if err != nil {
+					return nil, fmt.Errorf("pnm: pixel (%d, %d): %v", x, y, err)
+				}
+				world[y][x] = toCell(sample)
+			}
+		}
+	case bitmap:
+		row := make([]byte, (width+7)/8)
+		for y := range world {
+			if _, err := io.ReadFull(in, row); err != nil {
+				return nil, fmt.Errorf("pnm: row %d: %v", y, err)
+			}
+			for x := range world[y] {
+				world[y][x] = toCell(int(row[x/8] >> uint(7-x%8) & 1))
+			}
+		}
+	default:
+		bytesPerSample := 1
+		if maxval > 255 {
+			bytesPerSample = 2
+		}
+		row := make([]byte, width*bytesPerSample)
+		for y := range world {
+			if _, err := io.ReadFull(in, row); err != nil {
+				return nil, fmt.Errorf("pnm: row %d: %v", y, err)
+			}
+			for x := range world[y] {
+				sample := int(row[x])
+				if bytesPerSample == 2 {
+					sample = int(row[2*x])<<8 | int(row[2*x+1])
+				}
+				world[y][x] = toCell(sample)
+			}
+		}
+	}
+	return world, nil
+}
+
+// writePgm writes a world as a binary (P5) greymap with a maxval of 255, so cells are written as they are.
+func writePgm(w io.Writer, world [][]byte) error {
+	height := len(world)
+	width := 0
+	if height > 0 {
+		width = len(world[0])
+	}
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "P5\n%d %d\n255\n", width, height)
+	for _, row := range world {
+		out.Write(row)
+	}
+	return out.Flush()
+}
+
+// pnmToken returns the next whitespace separated token, skipping comments.
+// For the last header field, exactly one whitespace character after it is consumed,
+// which is what separates the header from binary pixel data.
+func pnmToken(in *bufio.Reader) (string, error) {
+	var token []byte
+	for {
+		c, err := in.ReadByte()
+		if err != nil {
+			if err == io.EOF && len(token) > 0 {
+				return string(token), nil
+			}
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+		switch {
+		case c == '#':
+			// a comment runs to the end of the line and also ends any token before it
+			if _, err := in.ReadString('\n'); err != nil {
+				return "", io.ErrUnexpectedEOF
+			}
+			if len(token) > 0 {
+				return string(token), nil
+			}
+		case isPnmSpace(c):
+			if len(token) > 0 {
+				return string(token), nil
+			}
+		default:
+			token = append(token, c)
+		}
+	}
+}
+
+func pnmNumber(in *bufio.Reader, name string) (int, error) {
+	token, err := pnmToken(in)
+	if err != nil {
+		return 0, fmt.Errorf("pnm: reading %v: %v", name, err)
+	}
+	n, err := strconv.Atoi(token)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("pnm: bad %v %q", name, token)
+	}
+	return n, nil
+}
+
+// pbmDigit returns the next 0 or 1 of a P1 raster.
+func pbmDigit(in *bufio.Reader) (int, error) {
+	for {
+		c, err := in.ReadByte()
+		if err == io.EOF {
+			return 0, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case c == '0' || c == '1':
+			return int(c - '0'), nil
+		case c == '#':
+			if _, err := in.ReadString('\n'); err != nil {
+				return 0, io.ErrUnexpectedEOF
+			}
+		case !isPnmSpace(c):
+			return 0, fmt.Errorf("unexpected %q", c)
+		}
+	}
+}
+
+func isPnmSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
@@ -0,0 +1,206 @@
+package gol
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"uk.ac.bris.cs/gameoflife/gol/engine"
+)
+
+// rleLineLength is the longest line written in the body of an RLE file, as recommended by the format.
+const rleLineLength = 70
+
+// parseRle reads a pattern in run length encoded format, e.g.
+//
+//	#N Glider
+//	x = 3, y = 3, rule = B3/S23
+//	bob$2bo$3o!
+//
+// and returns its cells as grey levels, 255 for alive and 0 for dead.
+// Two state patterns use b and o, multi-state patterns use . for dead and A to X, optionally
+// prefixed by p to y, for states 1 to 255, which are mapped to grey levels with the rule.
+func parseRle(r io.Reader, rule engine.Rule) ([][]byte, error) {
+	scanner := bufio.NewScanner(r)
+	width, height := -1, -1
+	var body strings.Builder
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+			continue
+		case width < 0:
+			var err error
+			width, height, err = parseRleHeader(line)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			body.WriteString(line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if width < 0 {
+		return nil, fmt.Errorf("rle: missing header line")
+	}
+
+	pattern := make([][]byte, height)
+	for i := range pattern {
+		pattern[i] = make([]byte, width)
+	}
+	x, y, count := 0, 0, 0
+	prefix := byte(0)
+	for _, c := range []byte(body.String()) {
+		if c >= '0' && c <= '9' {
+			count = count*10 + int(c-'0')
+			continue
+		}
+		run := count
+		if run == 0 {
+			run = 1
+		}
+		count = 0
+		var cell byte
+		switch {
+		case c == '!':
+			return pattern, nil
+		case c == '$':
+			x = 0
+			y += run
+			continue
+		case c >= 'p' && c <= 'y' && prefix == 0:
+			prefix = c
+			count = run
+			if run == 1 {
+				count = 0
+			}
+			continue
+		case c == 'b' || c == '.':
+			cell = 0
+		case c == 'o':
+			cell = 255
+		case c >= 'A' && c <= 'X':
+			state := int(c-'A') + 1
+			if prefix != 0 {
+				state += 24 * int(prefix-'p'+1)
+			}
+			cell = rule.Grey(state)
+		case c == ' ' || c == '\t':
+			continue
+		default:
+			return nil, fmt.Errorf("rle: unexpected %q", c)
+		}
+		prefix = 0
+		if y >= height || x+run > width {
+			return nil, fmt.Errorf("rle: cells beyond the %dx%d bounding box", width, height)
+		}
+		for i := 0; i < run; i++ {
+			pattern[y][x] = cell
+			x++
+		}
+	}
+	return nil, fmt.Errorf("rle: missing terminating !")
+}
+
+// parseRleHeader parses the "x = m, y = n, rule = abc" line.
+func parseRleHeader(line string) (int, int, error) {
+	width, height := -1, -1
+	for _, field := range strings.Split(line, ",") {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			return 0, 0, fmt.Errorf("rle: malformed header %q", line)
+		}
+		key := strings.TrimSpace(parts[0])
+		value := strings.TrimSpace(parts[1])
+		switch key {
+		case "x", "y":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return 0, 0, fmt.Errorf("rle: bad size %q in header", value)
+			}
+			if key == "x" {
+				width = n
+			} else {
+				height = n
+			}
+		}
+	}
+	if width < 0 || height < 0 {
+		return 0, 0, fmt.Errorf("rle: header %q needs both x and y", line)
+	}
+	return width, height, nil
+}
+
+// writeRle writes a world in run length encoded format, with the rule in the header.
+func writeRle(w io.Writer, world [][]byte, rule engine.Rule) error {
+	height := len(world)
+	width := 0
+	if height > 0 {
+		width = len(world[0])
+	}
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "x = %d, y = %d, rule = %v\n", width, height, rule)
+
+	var line strings.Builder
+	emit := func(run int, tag string) {
+		token := tag
+		if run > 1 {
+			token = strconv.Itoa(run) + tag
+		}
+		if line.Len()+len(token) > rleLineLength {
+			fmt.Fprintln(out, line.String())
+			line.Reset()
+		}
+		line.WriteString(token)
+	}
+	tag := func(cell byte) string {
+		if !rule.Generations() {
+			if cell == 255 {
+				return "o"
+			}
+			return "b"
+		}
+		state := rule.State(cell)
+		if state == 0 {
+			return "."
+		}
+		if state <= 24 {
+			return string(rune('A' + state - 1))
+		}
+		return string([]byte{byte('p' + (state-25)/24), byte('A' + (state-25)%24)})
+	}
+
+	blankRows := 0
+	for _, row := range world {
+		// trailing dead cells of a row are left out
+		end := len(row)
+		for end > 0 && tag(row[end-1]) == tag(0) {
+			end--
+		}
+		if end == 0 {
+			blankRows++
+			continue
+		}
+		if blankRows > 0 {
+			emit(blankRows, "$")
+			blankRows = 0
+		}
+		for x := 0; x < end; {
+			t := tag(row[x])
+			run := 1
+			for x+run < end && tag(row[x+run]) == t {
+				run++
+			}
+			emit(run, t)
+			x += run
+		}
+		blankRows = 1
+	}
+	emit(1, "!")
+	fmt.Fprintln(out, line.String())
+	return out.Flush()
+}
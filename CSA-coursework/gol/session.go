@@ -0,0 +1,84 @@
+package gol
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/rpc"
+
+	"uk.ac.bris.cs/gameoflife/gol/engine"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// newSession returns a random ID for a session run by the broker.
+func newSession() string {
+	id := make([]byte, 4)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// attachSession returns the params of the session p.Attach, starting from the turn it has reached,
+// so that the world can be read back from the broker and written out like the original controller would.
+func attachSession(p Params) (Params, error) {
+	broker := p.Broker
+	if broker == "" {
+		broker = DefaultBroker
+	}
+	client, err := rpc.Dial("tcp", broker)
+	if err != nil {
+		return p, err
+	}
+	defer client.Close()
+	res := new(Response)
+	if err := client.Call(BrokerAttach, Request{Session: p.Attach}, res); err != nil {
+		return p, err
+	}
+	session := res.Parameter
+	session.Broker, session.Attach = p.Broker, p.Attach
+	session.StartTurn = res.Turns
+	return session, nil
+}
+
+// sendWorld tells the GUI about the world a run starts from, after turn p.StartTurn: its size, its cells
+// as CellsFlipped, or CellsUpdated for the dying states of a Generations rule, and that it is executing.
+func sendWorld(c distributorChannels, p Params, world [][]byte) error {
+	rule, err := engine.ParseRule(p.Rule)
+	if err != nil {
+		return err
+	}
+	c.events <- WorldSize{CompletedTurns: p.StartTurn, Width: p.ImageWidth, Height: p.ImageHeight}
+	flipped := CellsFlipped{CompletedTurns: p.StartTurn}
+	updated := CellsUpdated{CompletedTurns: p.StartTurn}
+	for y, row := range world {
+		for x, cell := range row {
+			if cell == 0 {
+				continue
+			}
+			if rule.Generations() {
+				updated.Cells = append(updated.Cells, util.Cell{X: x, Y: y})
+				updated.States = append(updated.States, cell)
+			} else {
+				flipped.Cells = append(flipped.Cells, util.Cell{X: x, Y: y})
+			}
+		}
+	}
+	if rule.Generations() {
+		c.events <- updated
+	} else {
+		c.events <- flipped
+	}
+	c.events <- StateChange{CompletedTurns: p.StartTurn, NewState: Executing}
+	return nil
+}
+
+// aliveCells returns the alive cells of a world.
+func aliveCells(world [][]byte) []util.Cell {
+	var cells []util.Cell
+	for y, row := range world {
+		for x, cell := range row {
+			if cell == 255 {
+				cells = append(cells, util.Cell{X: x, Y: y})
+			}
+		}
+	}
+	return cells
+}
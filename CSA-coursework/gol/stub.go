@@ -1,6 +1,11 @@
 package gol
 
-import "uk.ac.bris.cs/gameoflife/util"
+import (
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/gol/engine"
+	"uk.ac.bris.cs/gameoflife/util"
+)
 
 var BrokerAliveCells = "Broker.GolAliveCells"
 var Initializer = "Broker.GolInitializer"
@@ -8,26 +13,92 @@ var BrokerKey = "Broker.GolKey"
 var Key = "Server.KeyGol"
 var ProcessGol = "Server.ProcessWorld"
 var Live = "Broker.GetLive"
+var BrokerRegister = "Broker.Register"
+var BrokerDeregister = "Broker.Deregister"
+var BrokerHeartbeat = "Broker.Heartbeat"
+var BrokerAttach = "Broker.Attach"
+var BrokerWait = "Broker.Wait"
+var BrokerDetach = "Broker.Detach"
+var StripLoad = "Strip.Load"
+var StripStep = "Strip.Step"
+var StripRows = "Strip.Rows"
+
+// DefaultBroker is where the controller finds the broker when Params.Broker is empty.
+// It is the port the broker listens on by default.
+const DefaultBroker = "127.0.0.1:8030"
+
+// HeartbeatInterval is how often workers tell the broker they are alive.
+// A worker missing three heartbeats in a row is taken to be lost.
+const HeartbeatInterval = time.Second
 
 //ver ProcessSegment = "worker"
 
 // Request represents the data sent to the GOL server
 type Request struct {
-	World     [][]byte // The current state of the world
-	Parameter Params   // Game parameters including dimensions and turns
-	P         bool     // For pause
-	S         bool     // For save
+	World     [][]byte        // The current state of the world
+	Grid      *engine.BitGrid // Rows sent packed 64 cells to a word instead of World, see PackRows
+	Parameter Params          // Game parameters including dimensions and turns
+	P         bool            // For pause
+	S         bool            // For save
 	K         bool
+	A         bool // For autosave: the oldest snapshot queued by the broker after turn Saved
+	Saved     int
 	Resume    bool
 	Start     int
 	End       int
+	Turn      int     // Turn the rows of a strip are at, for the Strip RPCs, or the controller has followed, for GetLive
+	Batch     int     // Turns a worker steps its strip by in one StripStep
+	Owners    []Owner // Which worker keeps which rows, sent with StripLoad
+	Session   string  // ID of the session the controller starts, attaches to or waits on
+}
+
+// Owner is a strip of rows [Start, End) that a worker keeps between turns, serving them at Address
+type Owner struct {
+	Start   int
+	End     int
+	Address string
+}
+
+// Registration is sent by a worker to the broker when it starts and when it shuts down
+type Registration struct {
+	Address  string // Where the worker serves RPCs, as host:port
+	Capacity int    // Share of the rows the worker is given, relative to the other workers
 }
 
 type Response struct {
-	World      [][]byte // The final state of the world
-	Turns      int      // Number of completed turns
+	World      [][]byte        // The final state of the world
+	Grid       *engine.BitGrid // Rows sent packed 64 cells to a word instead of World, see PackRows
+	Turns      int             // Number of completed turns
 	Slice      [][]byte
 	AliveCells []util.Cell // List of coordinates for alive cells
 	CellCount  int
 	End        bool
+	Lost       []WorkerLost  // Workers lost since the controller last asked, reported by GolAliveCells
+	Elapsed    time.Duration // Time a worker spent stepping in a StripStep, without fetching rows
+	Parameter  Params        // Params of the session a controller attaches to
+	Changes    []Change      // Cells changed on each turn stepped by a StripStep, or since the turn asked about by GetLive
+}
+
+// Change is the cells that changed state on a turn, and the states they changed to
+type Change struct {
+	Turn   int // The turn the cells changed on, which the world is after once they have changed
+	Cells  []util.Cell
+	States []uint8
+}
+
+// PackRows returns rows to send as either World or Grid. When packed, as the rows of a Packable rule can be,
+// they are sent as a Grid 8 times smaller than the rows themselves.
+func PackRows(rows [][]byte, packed bool) ([][]byte, *engine.BitGrid) {
+	if !packed || len(rows) == 0 {
+		return rows, nil
+	}
+	return nil, engine.PackWorld(rows)
+}
+
+// UnpackRows returns the rows sent by PackRows.
+func UnpackRows(world [][]byte, grid *engine.BitGrid) [][]byte {
+	if grid != nil {
+		return grid.Unpack()
+	}
+	return world
 }
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+)
+
+// TestIOError tests that an image that can't be read or written makes Run return an error,
+// after reporting it as an IOError event, rather than panicking.
+func TestIOError(t *testing.T) {
+	t.Run("missing-input", func(t *testing.T) {
+		p := gol.Params{ImageWidth: 16, ImageHeight: 16, Turns: 10, Threads: 1, Pattern: "images/missing.rle"}
+		ioErrors, final, err := runErrors(p)
+		if err == nil {
+			t.Fatal("ERROR: expected Run to return an error for a missing pattern")
+		}
+		if len(ioErrors) != 1 || ioErrors[0].Err != err {
+			t.Errorf("ERROR: expected one IOError event carrying %v, got %v", err, ioErrors)
+		}
+		if final {
+			t.Error("ERROR: no turns should run when the initial image can't be read")
+		}
+	})
+
+	t.Run("unwritable-output", func(t *testing.T) {
+		// a directory in the way of the final image stops it being created
+		_ = os.Mkdir("out", os.ModePerm)
+		path := "out/20x20x0.pgm"
+		if err := os.Mkdir(path, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(path)
+
+		p := gol.Params{ImageWidth: 20, ImageHeight: 20, Turns: 0, Threads: 1, Pattern: "images/glider.rle"}
+		ioErrors, final, err := runErrors(p)
+		if err == nil {
+			t.Fatal("ERROR: expected Run to return an error when the final image can't be written")
+		}
+		if len(ioErrors) != 1 || ioErrors[0].Err != err {
+			t.Errorf("ERROR: expected one IOError event carrying %v, got %v", err, ioErrors)
+		}
+		if !final {
+			t.Error("ERROR: expected a FinalTurnComplete event before the failed output")
+		}
+	})
+}
+
+// runErrors runs the Game of Life and returns its IOError events, whether it got as far as
+// FinalTurnComplete, and the error returned by Run.
+func runErrors(p gol.Params) ([]gol.IOError, bool, error) {
+	events := make(chan gol.Event)
+	result := make(chan error, 1)
+	go func() {
+		result <- gol.Run(p, events, nil)
+	}()
+	var ioErrors []gol.IOError
+	final := false
+	for event := range events {
+		switch e := event.(type) {
+		case gol.IOError:
+			ioErrors = append(ioErrors, e)
+		case gol.FinalTurnComplete:
+			final = true
+		}
+	}
+	return ioErrors, final, <-result
+}
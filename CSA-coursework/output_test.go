@@ -0,0 +1,81 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+)
+
+// TestOutputName tests that images are read from Params.InputDir and written to Params.OutputDir,
+// named by the Params.OutputName template.
+func TestOutputName(t *testing.T) {
+	input := t.TempDir()
+	image, err := ioutil.ReadFile("images/16x16.pgm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(input, "16x16.pgm"), image, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{"", `^16x16x100\.pgm$`},
+		{"{w}x{h}-t{turn}-{ts}.pgm", `^16x16-t100-\d{8}-\d{6}\.\d{3}\.pgm$`},
+		{"{w}x{h}x{turn}-{pid}", `^16x16x100-\d+\.pgm$`},
+		{"run-{turn}", `^run-100\.pgm$`},
+	}
+	for _, test := range tests {
+		t.Run(test.template, func(t *testing.T) {
+			output := t.TempDir()
+			p := gol.Params{ImageWidth: 16, ImageHeight: 16, Turns: 100, Threads: 1,
+				InputDir: input, OutputDir: output, OutputName: test.template}
+			events := make(chan gol.Event)
+			go gol.Run(p, events, nil)
+			var filename string
+			for event := range events {
+				if e, ok := event.(gol.ImageOutputComplete); ok {
+					filename = e.Filename
+				}
+			}
+			files, err := ioutil.ReadDir(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(files) != 1 || !regexp.MustCompile(test.expected).MatchString(files[0].Name()) {
+				t.Fatalf("ERROR: expected one file matching %v in the output directory, got %v", test.expected, files)
+			}
+			if filename != files[0].Name() && filename+".pgm" != files[0].Name() {
+				t.Errorf("ERROR: ImageOutputComplete reported %v but %v was written", filename, files[0].Name())
+			}
+			expectedAlive := readAliveCells("check/images/16x16x100.pgm", 16, 16)
+			assertEqualBoard(t, readAliveCells(filepath.Join(output, files[0].Name()), 16, 16), expectedAlive, p)
+		})
+	}
+
+	// a format asked for replaces the extension in the template
+	output := t.TempDir()
+	p := gol.Params{ImageWidth: 16, ImageHeight: 16, Turns: 1, Threads: 1, Format: "rle",
+		InputDir: input, OutputDir: output, OutputName: "{w}x{h}x{turn}.pgm"}
+	events := make(chan gol.Event)
+	go gol.Run(p, events, nil)
+	for event := range events {
+		if e, ok := event.(gol.ImageOutputComplete); ok && filepath.Base(e.Path) != "16x16x1.rle" {
+			t.Errorf("ERROR: expected 16x16x1.rle to be written, got %v", e.Path)
+		}
+	}
+	if _, err := ioutil.ReadFile(filepath.Join(output, "16x16x1.rle")); err != nil {
+		t.Error(err)
+	}
+
+	for _, template := range []string{"{width}", "{turn", "a}b", "runs/{turn}"} {
+		if gol.CheckOutputName(template) == nil {
+			t.Errorf("ERROR: expected %q to be rejected", template)
+		}
+	}
+}
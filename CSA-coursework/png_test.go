@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestPng tests that PNG images are scaled, drawn in each palette, and written by the 'n' key
+// whatever the output format.
+func TestPng(t *testing.T) {
+	const scale = 4
+	for _, palette := range []string{"classic", "inverted", "heat"} {
+		t.Run(palette, func(t *testing.T) {
+			p := gol.Params{ImageWidth: 16, ImageHeight: 16, Turns: 100, Threads: 1,
+				Format: "png", Palette: palette, Scale: scale, OutputDir: t.TempDir()}
+			alive := runFinal(p)
+			img := readPng(t, filepath.Join(p.OutputDir, "16x16x100.png"))
+			if size := img.Bounds().Size(); size.X != 16*scale || size.Y != 16*scale {
+				t.Fatalf("ERROR: expected a %dx%d image, got %v", 16*scale, 16*scale, size)
+			}
+
+			isAlive := make(map[util.Cell]bool)
+			for _, cell := range alive {
+				isAlive[cell] = true
+			}
+			for y := 0; y < 16; y++ {
+				for x := 0; x < 16; x++ {
+					c := color.NRGBAModel.Convert(img.At(x*scale+scale-1, y*scale+scale-1)).(color.NRGBA)
+					if c != color.NRGBAModel.Convert(img.At(x*scale, y*scale)) {
+						t.Fatalf("ERROR: cell (%d, %d) is not drawn as a %dx%d square", x, y, scale, scale)
+					}
+					var ok bool
+					switch {
+					case palette == "classic":
+						ok = (c.R == 255) == isAlive[util.Cell{X: x, Y: y}] && c.R == c.G
+					case palette == "inverted":
+						ok = (c.R == 0) == isAlive[util.Cell{X: x, Y: y}] && c.R == c.G
+					case isAlive[util.Cell{X: x, Y: y}]:
+						// alive cells go from white to red as they age
+						ok = c.R > 0 && c.R >= c.G && c.G >= c.B
+					default:
+						ok = c.R == 0 && c.G == 0 && c.B == 0
+					}
+					if !ok {
+						t.Fatalf("ERROR: cell (%d, %d), alive %v, drawn as %v with the %v palette", x, y, isAlive[util.Cell{X: x, Y: y}], c, palette)
+					}
+				}
+			}
+		})
+	}
+
+	t.Run("key", func(t *testing.T) {
+		p := gol.Params{ImageWidth: 16, ImageHeight: 16, Turns: 100000000, Threads: 1, Format: "rle", OutputDir: t.TempDir()}
+		events := make(chan gol.Event)
+		keyPresses := make(chan rune, 2)
+		go gol.Run(p, events, keyPresses)
+		keyPresses <- 'n'
+		var paths []string
+	loop:
+		for event := range events {
+			switch e := event.(type) {
+			case gol.ImageOutputComplete:
+				paths = append(paths, e.Path)
+				if len(paths) == 1 {
+					keyPresses <- 'q'
+				}
+			case gol.StateChange:
+				if e.NewState == gol.Quitting {
+					break loop
+				}
+			}
+		}
+		if len(paths) != 2 || !strings.HasSuffix(paths[0], ".png") || !strings.HasSuffix(paths[1], ".rle") {
+			t.Fatalf("ERROR: expected a .png image for 'n' and an .rle image for 'q', got %v", paths)
+		}
+		readPng(t, paths[0])
+	})
+}
+
+func readPng(t *testing.T, path string) image.Image {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	img, err := png.Decode(file)
+	if err != nil {
+		t.Fatal(fmt.Errorf("%v: %v", path, err))
+	}
+	return img
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestPnm tests that the 16x16 and 64x64 images read the same when re-encoded as P1, P2, P4 and P5 files
+// with comments, other maxvals and pixel bytes that look like whitespace.
+func TestPnm(t *testing.T) {
+	for _, size := range []int{16, 64} {
+		expectedAlive := readAliveCells(fmt.Sprintf("check/images/%vx%vx0.pgm", size, size), size, size)
+		alive := make(map[util.Cell]bool)
+		for _, cell := range expectedAlive {
+			alive[cell] = true
+		}
+		encodings := map[string]func(b *bytes.Buffer){
+			"p1.pbm": func(b *bytes.Buffer) {
+				fmt.Fprintf(b, "P1\n# a comment\n%d %d\n", size, size)
+				for y := 0; y < size; y++ {
+					for x := 0; x < size; x++ {
+						if alive[util.Cell{X: x, Y: y}] {
+							b.WriteString("1")
+						} else {
+							b.WriteString("0")
+						}
+					}
+					b.WriteString("\n")
+				}
+			},
+			"p4.pbm": func(b *bytes.Buffer) {
+				fmt.Fprintf(b, "P4 %d\n#comment\n%d\n", size, size)
+				for y := 0; y < size; y++ {
+					row := make([]byte, (size+7)/8)
+					for x := 0; x < size; x++ {
+						if alive[util.Cell{X: x, Y: y}] {
+							row[x/8] |= 0x80 >> uint(x%8)
+						}
+					}
+					b.Write(row)
+				}
+			},
+			"p2.pgm": func(b *bytes.Buffer) {
+				fmt.Fprintf(b, "P2\n%d %d # size\n15\n", size, size)
+				for y := 0; y < size; y++ {
+					for x := 0; x < size; x++ {
+						sample := 3
+						if alive[util.Cell{X: x, Y: y}] {
+							sample = 12
+						}
+						fmt.Fprintf(b, "%d ", sample)
+					}
+					b.WriteString("\n")
+				}
+			},
+			"p5.pgm": func(b *bytes.Buffer) {
+				// dead cells are 0x20 and 0x0a, which a whitespace split would swallow
+				fmt.Fprintf(b, "P5\n# comment\n%d %d\n255\n", size, size)
+				for y := 0; y < size; y++ {
+					for x := 0; x < size; x++ {
+						switch {
+						case alive[util.Cell{X: x, Y: y}]:
+							b.WriteByte(0xff)
+						case (x+y)%2 == 0:
+							b.WriteByte(0x20)
+						default:
+							b.WriteByte(0x0a)
+						}
+					}
+				}
+			},
+			"p5-16bit.pgm": func(b *bytes.Buffer) {
+				fmt.Fprintf(b, "P5 %d %d 65535\n", size, size)
+				for y := 0; y < size; y++ {
+					for x := 0; x < size; x++ {
+						if alive[util.Cell{X: x, Y: y}] {
+							b.Write([]byte{0xc0, 0x00})
+						} else {
+							b.Write([]byte{0x20, 0x0a})
+						}
+					}
+				}
+			},
+		}
+		dir := t.TempDir()
+		for name, encode := range encodings {
+			var b bytes.Buffer
+			encode(&b)
+			path := filepath.Join(dir, name)
+			util.Check(os.WriteFile(path, b.Bytes(), 0644))
+			p := gol.Params{ImageWidth: size, ImageHeight: size, Turns: 0, Threads: 1, Pattern: path, PatternOffset: &util.Cell{}}
+			t.Run(fmt.Sprintf("%dx%d-%v", size, size, name), func(t *testing.T) {
+				assertEqualBoard(t, runFinal(p), expectedAlive, p)
+			})
+		}
+	}
+}
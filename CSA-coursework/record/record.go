@@ -0,0 +1,161 @@
+// Package record turns the events of a run into an animated GIF, so a run can be watched back
+// without a display. It only needs the Event stream, so it works the same with or without SDL.
+package record
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+)
+
+// frameDelay is the time each frame is shown for, in hundredths of a second.
+const frameDelay = 10
+
+// Options says where a run is recorded to and how often.
+type Options struct {
+	Path   string // The .gif file to write.
+	Every  int    // Turns between frames. The first and final turns are always recorded.
+	Scale  int    // Width and height in pixels of each cell.
+	Frames int    // Most frames to record, including the first and final ones. 0 means no limit.
+}
+
+// Check returns an error if the options can't be recorded.
+func Check(o Options) error {
+	if strings.ToLower(filepath.Ext(o.Path)) != ".gif" {
+		return fmt.Errorf("invalid recording %q: only .gif files can be recorded", o.Path)
+	}
+	if o.Every < 1 || o.Scale < 1 || o.Frames < 0 {
+		return fmt.Errorf("invalid recording: every %d turns at scale %d with at most %d frames", o.Every, o.Scale, o.Frames)
+	}
+	return nil
+}
+
+// recorder keeps a copy of the world described by the events, as grey levels.
+type recorder struct {
+	options   Options
+	world     [][]byte
+	frames    []*image.Paletted
+	lastFrame int
+	palette   color.Palette
+}
+
+// Run copies every event from in to out, recording the world they describe.
+// Once the run quits, out is closed and the animation is written to Options.Path.
+// Nothing is written if the world was never sized by a WorldSize event.
+func Run(o Options, in <-chan gol.Event, out chan<- gol.Event) error {
+	defer close(out)
+	r := recorder{options: o, lastFrame: -1}
+	// Cells are stored as grey levels, so they index the palette directly
+	for i := 0; i < 256; i++ {
+		r.palette = append(r.palette, color.Gray{Y: uint8(i)})
+	}
+
+	for event := range in {
+		r.handle(event)
+		out <- event
+		if e, ok := event.(gol.StateChange); ok && e.NewState == gol.Quitting {
+			break
+		}
+	}
+	if len(r.frames) == 0 {
+		// the run never started, and the reason has been reported by it
+		return nil
+	}
+
+	animation := gif.GIF{Image: r.frames, Delay: make([]int, len(r.frames))}
+	for i := range animation.Delay {
+		animation.Delay[i] = frameDelay
+	}
+	file, err := os.Create(o.Path)
+	if err != nil {
+		return err
+	}
+	if err := gif.EncodeAll(file, &animation); err != nil {
+		file.Close()
+		return err
+	}
+	fmt.Println("File", o.Path, "recording done!")
+	return file.Close()
+}
+
+// handle updates the world for an event and captures a frame when one is due.
+func (r *recorder) handle(event gol.Event) {
+	switch e := event.(type) {
+	case gol.WorldSize:
+		r.world = make([][]byte, e.Height)
+		for y := range r.world {
+			r.world[y] = make([]byte, e.Width)
+		}
+	case gol.CellFlipped:
+		r.flip(e.Cell.X, e.Cell.Y)
+	case gol.CellsFlipped:
+		for _, cell := range e.Cells {
+			r.flip(cell.X, cell.Y)
+		}
+	case gol.CellsUpdated:
+		for i, cell := range e.Cells {
+			if r.world != nil {
+				r.world[cell.Y][cell.X] = e.States[i]
+			}
+		}
+	case gol.StateChange:
+		// the initial cells have all been sent once the run starts executing
+		if r.lastFrame < 0 {
+			r.capture(e.CompletedTurns, false)
+		}
+	case gol.TurnComplete:
+		if r.lastFrame < 0 || e.CompletedTurns-r.lastFrame >= r.options.Every {
+			r.capture(e.CompletedTurns, false)
+		}
+	case gol.FinalTurnComplete:
+		r.capture(e.CompletedTurns, true)
+	}
+}
+
+func (r *recorder) flip(x, y int) {
+	if r.world == nil {
+		return
+	}
+	if r.world[y][x] == 0 {
+		r.world[y][x] = 255
+	} else {
+		r.world[y][x] = 0
+	}
+}
+
+// capture adds a frame of the world after the given turn.
+// Once there are Options.Frames frames, only the final one replaces the last frame.
+func (r *recorder) capture(turn int, final bool) {
+	if r.world == nil || (turn == r.lastFrame && !final) {
+		return
+	}
+	full := r.options.Frames > 0 && len(r.frames) >= r.options.Frames
+	if full && !final {
+		return
+	}
+
+	scale := r.options.Scale
+	frame := image.NewPaletted(image.Rect(0, 0, len(r.world[0])*scale, len(r.world)*scale), r.palette)
+	for y, row := range r.world {
+		for x, cell := range row {
+			for dy := 0; dy < scale; dy++ {
+				offset := frame.PixOffset(x*scale, y*scale+dy)
+				for dx := 0; dx < scale; dx++ {
+					frame.Pix[offset+dx] = cell
+				}
+			}
+		}
+	}
+	if full || (final && turn == r.lastFrame) {
+		r.frames[len(r.frames)-1] = frame
+	} else {
+		r.frames = append(r.frames, frame)
+	}
+	r.lastFrame = turn
+}
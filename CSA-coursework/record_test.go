@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"image/gif"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/record"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestRecord tests that a run recorded from its events has a frame every few turns, up to the frame limit,
+// and that the last frame is the final world.
+func TestRecord(t *testing.T) {
+	tests := []struct {
+		every  int
+		frames int
+		scale  int
+		count  int
+	}{
+		{1, 0, 1, 9},
+		{2, 0, 3, 5},
+		{2, 3, 2, 3},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("every-%d-frames-%d", test.every, test.frames), func(t *testing.T) {
+			options := record.Options{Path: filepath.Join(t.TempDir(), "run.gif"), Every: test.every, Scale: test.scale, Frames: test.frames}
+			p := gol.Params{ImageWidth: 16, ImageHeight: 16, Turns: 8, Threads: 1, Pattern: "images/glider.rle", OutputDir: t.TempDir()}
+			events := make(chan gol.Event)
+			recorded := make(chan gol.Event)
+			go gol.Run(p, events, nil)
+			recordErr := make(chan error, 1)
+			go func() {
+				recordErr <- record.Run(options, events, recorded)
+			}()
+			var alive []util.Cell
+			for event := range recorded {
+				if e, ok := event.(gol.FinalTurnComplete); ok {
+					alive = e.Alive
+				}
+			}
+			if err := <-recordErr; err != nil {
+				t.Fatal(err)
+			}
+
+			file, err := os.Open(options.Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+			animation, err := gif.DecodeAll(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(animation.Image) != test.count {
+				t.Fatalf("ERROR: expected %d frames, got %d", test.count, len(animation.Image))
+			}
+			last := animation.Image[len(animation.Image)-1]
+			if size := last.Bounds().Size(); size.X != 16*test.scale || size.Y != 16*test.scale {
+				t.Fatalf("ERROR: expected %dx%d frames, got %v", 16*test.scale, 16*test.scale, size)
+			}
+			var framed []util.Cell
+			for y := 0; y < 16; y++ {
+				for x := 0; x < 16; x++ {
+					if r, _, _, _ := last.At(x*test.scale+test.scale-1, y*test.scale).RGBA(); r != 0 {
+						framed = append(framed, util.Cell{X: x, Y: y})
+					}
+				}
+			}
+			assertEqualBoard(t, framed, alive, p)
+		})
+	}
+}
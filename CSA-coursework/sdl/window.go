@@ -88,6 +88,20 @@ func (w *Window) FlipPixel(x, y int) {
 	w.pixels[4*(y*width+x)+3] = ^w.pixels[4*(y*width+x)+3]
 }
 
+// SetShade sets a pixel to the given grey level, so that intermediate cell states are drawn as shades.
+// A shade of 0 matches a dead pixel and 0xFF matches an alive one.
+func (w *Window) SetShade(x, y int, shade uint8) {
+	if x < 0 || y < 0 || x >= int(w.Width) || y >= int(w.Height) {
+		panic(fmt.Sprintf("CellsUpdated event at (%d, %d) is outside the bounds of the window.", x, y))
+	}
+
+	width := int(w.Width)
+	w.pixels[4*(y*width+x)+0] = shade
+	w.pixels[4*(y*width+x)+1] = shade
+	w.pixels[4*(y*width+x)+2] = shade
+	w.pixels[4*(y*width+x)+3] = shade
+}
+
 func (w *Window) CountPixels() int {
 	count := 0
 	for i := 0; i < int(w.Width) * int(w.Height) * 4; i += 4 {
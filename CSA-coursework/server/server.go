@@ -7,7 +7,7 @@ import (
 	"os"
 	"sync"
 	"uk.ac.bris.cs/gameoflife/gol"
-	"uk.ac.bris.cs/gameoflife/util"
+	"uk.ac.bris.cs/gameoflife/gol/engine"
 )
 
 // Global mutex for thread safety
@@ -27,15 +27,36 @@ type Server struct {
 // req contains the initial world state and parameters
 // res will contain the final world state and statistics
 func (s *Server) ProcessWorld(req gol.Request, res *gol.Response) error {
-	// Initialize server state
-	s.Turn = 0
+	rule, err := engine.ParseRule(req.Parameter.Rule)
+	if err != nil {
+		return err
+	}
+	topology, err := engine.ParseTopology(req.Parameter.Topology)
+	if err != nil {
+		return err
+	}
+	stepper, err := engine.New(req.Parameter.Engine, rule, topology, req.Parameter.Threads)
+	if err != nil {
+		return err
+	}
+	// Initialize server state, carrying on from a checkpoint's turn when resuming
+	s.Turn = req.Parameter.StartTurn
 	s.Resume = make(chan bool)
+	// Hashlife jumps through many turns at once, so the turn counter moves in leaps
+	if req.Parameter.Engine == "hashlife" {
+		return s.processHashLife(req, res, rule, topology)
+	}
 	mutex.Lock()
 	s.World = copySlice(req.World)
 	mutex.Unlock()
+	// The active engine only recomputes the tiles near cells that changed last turn
+	var region *engine.ActiveRegion
+	if req.Parameter.Engine == "active" {
+		region = engine.NewActiveRegion(req.Parameter.ImageWidth, req.Parameter.ImageHeight, engine.DefaultTileSize, rule, topology)
+	}
 
 	// Process all turns sequentially
-	for ; s.Turn < req.Parameter.Turns; s.Turn++ {
+	for s.Turn < req.Parameter.Turns {
 		mutex.Lock()
 		if s.Pause {
 			mutex.Unlock()
@@ -43,50 +64,56 @@ func (s *Server) ProcessWorld(req gol.Request, res *gol.Response) error {
 		} else {
 			mutex.Unlock()
 		} //avoiding race condition
-		s.World = nextState(req.Parameter, s.World, 0, req.Parameter.ImageHeight)
+		var world [][]byte
+		if region != nil {
+			world, _ = region.Step(s.World)
+		} else {
+			world = stepper.Step(s.World, 0, req.Parameter.ImageHeight)
+		}
 		mutex.Lock()
-		s.CellCount = len(calculateAliveCells(req.Parameter, s.World))
+		s.World = world
+		s.CellCount = len(stepper.Alive(world))
 		s.Turn++
 		mutex.Unlock()
 	}
-	if req.Parameter.Threads == 1 {
-		s.World = nextState(req.Parameter, s.World, 0, req.Parameter.ImageHeight)
-	} else {
-		//TODO: NEEDED TO BE OPTIMISED
-		// create a channel
-		chans := make([]chan [][]byte, req.Parameter.Threads)
-		for i := 0; i < req.Parameter.Threads; i++ {
-			chans[i] = make(chan [][]byte)
-			a := i * (req.Parameter.ImageHeight / req.Parameter.Threads)
-			b := (i + 1) * (req.Parameter.ImageHeight / req.Parameter.Threads)
-			if i == req.Parameter.Threads-1 {
-				b = req.Parameter.ImageHeight
-			}
-			mutex.Lock()
-			worldCopy := copySlice(s.World)
-			mutex.Unlock()
-			go workers(req.Parameter, worldCopy, chans[i], a, b)
+	// Prepare the response with final state
+	mutex.Lock()
+	res.World = s.World
+	res.Turns = s.Turn
+	res.AliveCells = stepper.Alive(s.World)
+	mutex.Unlock()
+	return nil
+}
 
+// processHashLife runs all turns with the hashlife engine
+func (s *Server) processHashLife(req gol.Request, res *gol.Response, rule engine.Rule, topology engine.Topology) error {
+	life, err := engine.NewHashLife(req.World, rule, topology)
+	if err != nil {
+		return err
+	}
+	mutex.Lock()
+	s.World = copySlice(req.World)
+	mutex.Unlock()
+	for turn := req.Parameter.StartTurn; turn < req.Parameter.Turns; {
+		mutex.Lock()
+		if s.Pause {
+			mutex.Unlock()
+			<-s.Resume
+		} else {
+			mutex.Unlock()
 		}
-		//combine all the strips produced by workers
-		for i := 0; i < req.Parameter.Threads; i++ {
-			strip := <-chans[i]
-			startRow := i * (req.Parameter.ImageHeight / req.Parameter.Threads)
-			for r, row := range strip {
-				mutex.Lock()
-				req.World[startRow+r] = row
-				mutex.Unlock()
-			}
-		}
+		turn += life.Advance(req.Parameter.Turns - turn)
+		world := life.Grid().Unpack()
 		mutex.Lock()
-		s.World = copySlice(req.World)
+		s.World = world
+		s.Turn = turn
+		s.CellCount = life.Count()
 		mutex.Unlock()
 	}
-	// Prepare the response with final state
 	mutex.Lock()
 	res.World = s.World
 	res.Turns = s.Turn
-	res.AliveCells = calculateAliveCells(req.Parameter, s.World)
+	res.AliveCells = life.Grid().AliveCells()
 	mutex.Unlock()
 	return nil
 }
@@ -119,79 +146,6 @@ func (s *Server) KeyGol(req gol.Request, res *gol.Response) error {
 	return nil
 }
 
-// nextState calculates the next state of the world according to Game of Life rules
-// Parameters:
-// - p: game parameters including world dimensions
-// - world: current world state
-// - start, end: range of rows to process
-// Returns the next state of the world
-func nextState(p gol.Params, world [][]byte, start, end int) [][]byte {
-	newWorld := make([][]byte, end-start)
-	for i := range newWorld {
-		newWorld[i] = make([]byte, p.ImageWidth)
-	}
-	//define the relative 8 position of the 8 neighboring cells
-	directions := [8][2]int{
-		{-1, -1}, {-1, 0}, {-1, 1},
-		{0, -1}, {0, 1},
-		{1, -1}, {1, 0}, {1, 1},
-	}
-
-	// Iterate over each row in the specified range
-	for row := start; row < end; row++ {
-		// Iterate over each column in the current row
-		for col := 0; col < p.ImageWidth; col++ {
-			alive := 0 // Counter for alive neighbors
-
-			// Check all 8 neighboring cells
-			for _, dir := range directions {
-				// Calculate the position of the neighboring cell
-				newRow := (row + dir[0] + p.ImageHeight) % p.ImageHeight
-				newCol := (col + dir[1] + p.ImageWidth) % p.ImageWidth
-				// Check if the neighboring cell is alive
-				if world[newRow][newCol] == 255 {
-					alive++
-				}
-			}
-
-			// Apply the Game of Life rules
-			if world[row][col] == 255 {
-				if alive < 2 || alive > 3 {
-					newWorld[row-start][col] = 0
-				} else {
-					newWorld[row-start][col] = 255
-				}
-			} else if world[row][col] == 0 {
-				if alive == 3 {
-					newWorld[row-start][col] = 255
-				} else {
-					newWorld[row-start][col] = 0
-				}
-			}
-		}
-	}
-	return newWorld
-}
-
-// calculateAliveCells returns a slice of coordinates for all live cells
-func calculateAliveCells(p gol.Params, world [][]byte) []util.Cell {
-	var cells []util.Cell
-	for row := 0; row < p.ImageHeight; row++ {
-		for col := 0; col < p.ImageWidth; col++ {
-			if world[row][col] == 255 {
-				cells = append(cells, util.Cell{X: col, Y: row})
-			}
-		}
-	}
-	return cells
-}
-
-func workers(p gol.Params, world [][]byte, result chan<- [][]byte, start, end int) {
-	worldPiece := nextState(p, world, start, end)
-	result <- worldPiece
-	close(result)
-}
-
 // copySlice creates a deep copy of a 2D byte slice
 func copySlice(src [][]byte) [][]byte {
 	dst := make([][]byte, len(src))
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"image"
+	"path/filepath"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestSoup tests that random soups are reproducible from their seed, have about the density asked for,
+// stay inside their area and have the seed in the names of their images and checkpoints.
+func TestSoup(t *testing.T) {
+	p := gol.Params{ImageWidth: 128, ImageHeight: 128, Turns: 0, Threads: 1, OutputDir: t.TempDir(),
+		Soup: 0.35, Seed: 42}
+	first, name := runSoup(p)
+	again, _ := runSoup(p)
+	assertEqualBoard(t, again, first, p)
+	if name != "128x128x0-s42" {
+		t.Errorf("ERROR: expected the image of a soup to be named 128x128x0-s42, got %v", name)
+	}
+	if density := float64(len(first)) / (128 * 128); density < 0.33 || density > 0.37 {
+		t.Errorf("ERROR: expected a density of about 0.35, got %v", density)
+	}
+
+	p.Seed = 43
+	other, _ := runSoup(p)
+	if len(other) == len(first) {
+		same := true
+		for i := range other {
+			same = same && other[i] == first[i]
+		}
+		if same {
+			t.Errorf("ERROR: expected seeds 42 and 43 to give different soups")
+		}
+	}
+
+	p.SoupArea = image.Rect(10, 20, 40, 30)
+	inside, _ := runSoup(p)
+	if len(inside) == 0 {
+		t.Errorf("ERROR: expected alive cells in the soup area")
+	}
+	for _, cell := range inside {
+		if !(image.Point{X: cell.X, Y: cell.Y}).In(p.SoupArea) {
+			t.Errorf("ERROR: expected cells only in %v, got %v", p.SoupArea, cell)
+			break
+		}
+	}
+
+	// a checkpoint keeps the seed, so a resumed run is named like the original
+	p = gol.Params{ImageWidth: 32, ImageHeight: 32, Turns: 10, Threads: 1, OutputDir: t.TempDir(),
+		Soup: 0.5, Seed: 7, Format: "golc"}
+	runSoup(p)
+	resumed := gol.Params{Turns: 20, Threads: 1, OutputDir: t.TempDir(),
+		Resume: filepath.Join(p.OutputDir, "32x32x10-s7.golc")}
+	if _, name := runSoup(resumed); name != "32x32x20-s7" {
+		t.Errorf("ERROR: expected the resumed run to be named 32x32x20-s7, got %v", name)
+	}
+}
+
+// runSoup runs the Game of Life and returns the alive cells after the final turn and the name of the final image.
+func runSoup(p gol.Params) ([]util.Cell, string) {
+	events := make(chan gol.Event)
+	go gol.Run(p, events, nil)
+	var alive []util.Cell
+	var name string
+	for event := range events {
+		switch e := event.(type) {
+		case gol.FinalTurnComplete:
+			alive = e.Alive
+		case gol.ImageOutputComplete:
+			name = e.Filename
+		}
+	}
+	return alive, name
+}
@@ -0,0 +1,265 @@
+package worker
+
+import (
+	"fmt"
+	"net/rpc"
+	"sync"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/gol/engine"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// peerDeadline is the longest a worker waits for rows from another worker. It is shorter than the three missed
+// heartbeats the broker waits for, so a worker waiting on a lost peer says so before the broker gives up on it.
+const peerDeadline = 2 * time.Second
+
+// Strip is the RPC service through which a worker keeps rows of the world between turns.
+// The broker loads the rows once, then only has them stepped a batch of turns at a time and pulls them back when
+// it needs the world. The rows around the strip that a batch depends on are fetched from the workers keeping them,
+// so a batch only moves the halo rows across the network rather than the whole world.
+type Strip struct {
+	mutex        sync.Mutex
+	stepper      engine.Engine
+	topology     engine.Topology
+	radius       int
+	packed       bool // Rows are sent packed, see gol.PackRows
+	height       int
+	width        int
+	start        int
+	end          int
+	turn         int      // Turn rows are at
+	rows         [][]byte // Rows [start, end) of the world
+	previous     [][]byte // The same rows before the last batch, which slower workers may still be fetching
+	previousTurn int
+	owners       []gol.Owner
+	peers        map[string]*rpc.Client // Workers rows have been fetched from, by address
+}
+
+// fetch is a range of rows [start, end) kept by the worker at address.
+type fetch struct {
+	start, end int
+	address    string
+}
+
+// Load replaces the kept rows with those sent in req.World or req.Grid, which are rows [req.Start, req.End)
+// of the world after turn req.Turn.
+// req.Owners lists every strip of the world, so that halo rows can be fetched from the workers keeping them.
+func (s *Strip) Load(req gol.Request, res *gol.Response) error {
+	rule, err := engine.ParseRule(req.Parameter.Rule)
+	if err != nil {
+		return err
+	}
+	topology, err := engine.ParseTopology(req.Parameter.Topology)
+	if err != nil {
+		return err
+	}
+	// The rows are padded before being stepped, so they are stepped as a plane
+	stepper, err := engine.New(req.Parameter.Engine, rule, engine.Plane, req.Parameter.Threads)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, peer := range s.peers {
+		peer.Close()
+	}
+	s.stepper, s.topology, s.radius, s.packed = stepper, topology, rule.Radius, rule.Packable()
+	s.height, s.width = req.Parameter.ImageHeight, req.Parameter.ImageWidth
+	s.start, s.end = req.Start, req.End
+	s.turn = req.Turn
+	s.rows, s.previous = gol.UnpackRows(req.World, req.Grid), nil
+	s.owners, s.peers = req.Owners, make(map[string]*rpc.Client)
+	return nil
+}
+
+// Rows answers rows [req.Start, req.End) of the strip as they were after turn req.Turn,
+// which may be the turn before the last batch for a worker that has already stepped.
+func (s *Strip) Rows(req gol.Request, res *gol.Response) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var rows [][]byte
+	switch req.Turn {
+	case s.turn:
+		rows = s.rows
+	case s.previousTurn:
+		rows = s.previous
+	}
+	if rows == nil {
+		return fmt.Errorf("rows of turn %v are gone, the strip is after turn %v", req.Turn, s.turn)
+	}
+	if req.Start < s.start || req.End > s.end || req.Start > req.End {
+		return fmt.Errorf("rows [%v, %v) are not in the strip [%v, %v)", req.Start, req.End, s.start, s.end)
+	}
+	res.World, res.Grid = gol.PackRows(rows[req.Start-s.start:req.End-s.start], s.packed)
+	res.Turns = req.Turn
+	return nil
+}
+
+// Step advances the strip from turn req.Turn by req.Batch turns, answering the number of alive cells it then has
+// and the cells of the strip that changed on each turn.
+// The rows req.Batch halos deep around the strip are fetched, and the padded strip is stepped with one halo fewer
+// on each side every turn, so the rows of the strip itself are right after the last turn.
+// The mutex isn't held while fetching, as the workers being fetched from are fetching from this one too.
+func (s *Strip) Step(req gol.Request, res *gol.Response) error {
+	s.mutex.Lock()
+	if s.rows == nil || req.Turn != s.turn {
+		s.mutex.Unlock()
+		return fmt.Errorf("cannot step from turn %v, the strip is after turn %v", req.Turn, s.turn)
+	}
+	rows, owners := s.rows, s.owners
+	stepper, topology, radius := s.stepper, s.topology, s.radius
+	start, end, height, width := s.start, s.end, s.height, s.width
+	s.mutex.Unlock()
+
+	batch := req.Batch
+	if batch < 1 {
+		batch = 1
+	}
+	depth := batch * radius
+	fetched := make(map[int][]byte)
+	for _, f := range fetches(topology, start, end, depth, height, width, owners) {
+		got, err := s.fetch(f, req.Turn)
+		if err != nil {
+			return fmt.Errorf("peer %v: %v", f.address, err)
+		}
+		for i, row := range got {
+			fetched[f.start+i] = row
+		}
+	}
+
+	began := time.Now()
+	padded := make([][]byte, end-start+2*depth)
+	var dead [][2]int
+	for i := range padded {
+		padded[i] = make([]byte, width+2*depth)
+		for j := range padded[i] {
+			row, col, ok := topology.Wrap(start-depth+i, j-depth, height, width)
+			if !ok {
+				dead = append(dead, [2]int{i, j})
+				continue
+			}
+			if row >= start && row < end {
+				padded[i][j] = rows[row-start][col]
+			} else {
+				padded[i][j] = fetched[row][col]
+			}
+		}
+	}
+	changes := make([]gol.Change, batch)
+	for turn := 1; turn <= batch; turn++ {
+		stepped := stepper.Step(padded, turn*radius, len(padded)-turn*radius)
+		// the rows of the strip itself are right after every turn, as the halos only shrink to them on the last
+		change := gol.Change{Turn: req.Turn + turn}
+		for i := depth; i < len(padded)-depth; i++ {
+			before, after := padded[i], stepped[i-turn*radius]
+			for j := depth; j < depth+width; j++ {
+				if before[j] != after[j] {
+					change.Cells = append(change.Cells, util.Cell{X: j - depth, Y: start + i - depth})
+					change.States = append(change.States, after[j])
+				}
+			}
+		}
+		changes[turn-1] = change
+		copy(padded[turn*radius:], stepped)
+		// cells past a dead edge stay dead, even when they would be born
+		for _, cell := range dead {
+			padded[cell[0]][cell[1]] = 0
+		}
+	}
+	next := padded[depth : len(padded)-depth]
+	count := 0
+	for i := range next {
+		next[i] = next[i][depth : len(next[i])-depth]
+		for _, cell := range next[i] {
+			if cell == 255 {
+				count++
+			}
+		}
+	}
+	elapsed := time.Since(began)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if req.Turn != s.turn {
+		return fmt.Errorf("the strip was loaded again while stepping from turn %v", req.Turn)
+	}
+	s.previous, s.previousTurn, s.rows = s.rows, s.turn, next
+	s.turn += batch
+	res.Turns = s.turn
+	res.CellCount = count
+	res.Elapsed = elapsed
+	res.Changes = changes
+	return nil
+}
+
+// fetch fetches the rows of f after turn, giving up after peerDeadline.
+// The worker keeping them is dialled the first time rows are fetched from it.
+func (s *Strip) fetch(f fetch, turn int) ([][]byte, error) {
+	s.mutex.Lock()
+	client := s.peers[f.address]
+	s.mutex.Unlock()
+	if client == nil {
+		dialled, err := rpc.Dial("tcp", f.address)
+		if err != nil {
+			return nil, err
+		}
+		s.mutex.Lock()
+		if client = s.peers[f.address]; client == nil {
+			client = dialled
+			s.peers[f.address] = client
+		} else {
+			dialled.Close()
+		}
+		s.mutex.Unlock()
+	}
+	res := new(gol.Response)
+	call := client.Go(gol.StripRows, gol.Request{Turn: turn, Start: f.start, End: f.end}, res, nil)
+	select {
+	case <-call.Done:
+		if call.Error != nil {
+			return nil, call.Error
+		}
+	case <-time.After(peerDeadline):
+		return nil, fmt.Errorf("no answer within %v", peerDeadline)
+	}
+	rows := gol.UnpackRows(res.World, res.Grid)
+	if len(rows) != f.end-f.start {
+		return nil, fmt.Errorf("expected %v rows, got %v", f.end-f.start, len(rows))
+	}
+	return rows, nil
+}
+
+// fetches returns the rows outside [start, end) that stepping it with halos depth rows deep depends on,
+// as a range of rows from each owner keeping some. Those are the halo rows on either side and, on topologies
+// that mirror rows across the side edges, the mirrored rows as well.
+func fetches(topology engine.Topology, start, end, depth, height, width int, owners []gol.Owner) []fetch {
+	needed := make(map[int]bool)
+	for i := start - depth; i < end+depth; i++ {
+		// a row's cells all wrap onto the same row, except those past the side edges
+		for _, col := range []int{0, -1} {
+			row, _, ok := topology.Wrap(i, col, height, width)
+			if ok && (row < start || row >= end) {
+				needed[row] = true
+			}
+		}
+	}
+	var ranges []fetch
+	for _, owner := range owners {
+		f := fetch{start: owner.End, end: owner.Start, address: owner.Address}
+		for row := owner.Start; row < owner.End; row++ {
+			if needed[row] {
+				if row < f.start {
+					f.start = row
+				}
+				f.end = row + 1
+			}
+		}
+		if f.start < f.end {
+			ranges = append(ranges, f)
+		}
+	}
+	return ranges
+}
@@ -0,0 +1,101 @@
+// Package worker has what every GOL worker process shares, whichever port it serves on.
+package worker
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/rpc"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+)
+
+// Server answers the key presses the broker passes on to every worker.
+type Server struct{}
+
+// KeyGol exits the worker when 'k' is pressed, as the broker shuts the whole system down.
+func (s *Server) KeyGol(req gol.Request, res *gol.Response) error {
+	if req.K {
+		os.Exit(0)
+	}
+	return nil
+}
+
+// Main runs a worker process listening on defaultPort unless -port says otherwise, joins the broker and serves it until
+// the process exits. The worker1 to worker4 commands only differ in the port they listen on by default.
+func Main(defaultPort string) {
+	port := flag.String("port", defaultPort, "port to listen on")
+	brokerAddress := flag.String("broker", "127.0.0.1:8030", "address of the broker to register with")
+	address := flag.String("address", "", "address the broker reaches this worker at, defaults to 127.0.0.1 and the port")
+	capacity := flag.Int("capacity", 1, "share of the rows to take, relative to the other workers")
+	flag.Parse()
+
+	if err := rpc.Register(new(Server)); err != nil {
+		fmt.Println("Failed to serve:", err)
+		os.Exit(1)
+	}
+	listener, err := net.Listen("tcp", ":"+*port)
+	if err != nil {
+		fmt.Println("Failed to listen:", err)
+		os.Exit(1)
+	}
+	defer listener.Close()
+	// The broker only gives rows to registered workers, so one joins as soon as it can be reached
+	if *address == "" {
+		*address = "127.0.0.1:" + *port
+	}
+	if err := Join(*brokerAddress, *address, *capacity); err != nil {
+		fmt.Println("Failed to register with the broker:", err)
+		os.Exit(1)
+	}
+	rpc.Accept(listener)
+}
+
+// Join serves the worker's Strip and registers the worker serving at address with the broker, which gives it
+// rows to keep from the next turn on in proportion to capacity. The worker then sends a heartbeat every gol.HeartbeatInterval, registering again
+// if the broker has since taken it to be lost or been restarted. When the process is interrupted or terminated
+// the worker is deregistered, so its rows go to the other workers, and the process exits.
+func Join(brokerAddress, address string, capacity int) error {
+	if err := rpc.Register(new(Strip)); err != nil {
+		return err
+	}
+	broker, err := rpc.Dial("tcp", brokerAddress)
+	if err != nil {
+		return err
+	}
+	registration := gol.Registration{Address: address, Capacity: capacity}
+	if err := broker.Call(gol.BrokerRegister, registration, new(gol.Response)); err != nil {
+		broker.Close()
+		return err
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		heartbeat := time.NewTicker(gol.HeartbeatInterval)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-heartbeat.C:
+				if broker.Call(gol.BrokerHeartbeat, registration, new(gol.Response)) == nil {
+					continue
+				}
+				// the connection may be gone along with the broker, so it is dialled again
+				broker.Close()
+				if redialled, err := rpc.Dial("tcp", brokerAddress); err == nil {
+					broker = redialled
+					broker.Call(gol.BrokerRegister, registration, new(gol.Response))
+				}
+			case <-stop:
+				broker.Call(gol.BrokerDeregister, registration, new(gol.Response))
+				broker.Close()
+				os.Exit(0)
+			}
+		}
+	}()
+	return nil
+}
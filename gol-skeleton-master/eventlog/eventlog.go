@@ -0,0 +1,46 @@
+// Package eventlog writes the events of a run as JSON lines, so that other programs can follow a run
+// without a display, e.g. when the world itself is streamed through stdin and stdout.
+package eventlog
+
+import (
+	"encoding/json"
+	"io"
+	"reflect"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+)
+
+// Run writes every event to w as a line of JSON until the events channel is closed.
+// Each line is an object with the fields of the event, its "type", e.g. "TurnComplete",
+// and its "message", the event as printed by the GUI, if it has one.
+// Events are still drained after a failed write, so the run isn't left blocked, and the first error is returned.
+func Run(events <-chan gol.Event, w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	var err error
+	for event := range events {
+		if err == nil {
+			err = encoder.Encode(fields(event))
+		}
+	}
+	return err
+}
+
+// fields returns the JSON object written for an event.
+func fields(event gol.Event) map[string]interface{} {
+	object := map[string]interface{}{}
+	if encoded, err := json.Marshal(event); err == nil {
+		json.Unmarshal(encoded, &object)
+	}
+	object["type"] = reflect.TypeOf(event).Name()
+	if message := event.String(); message != "" {
+		object["message"] = message
+	}
+	// fields that don't marshal as anything readable
+	switch e := event.(type) {
+	case gol.IOError:
+		object["Err"] = e.Err.Error()
+	case gol.StateChange:
+		object["NewState"] = e.NewState.String()
+	}
+	return object
+}
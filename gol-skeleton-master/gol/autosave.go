@@ -0,0 +1,74 @@
+package gol
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultAutosaveKeep is the number of autosaves kept when Params.AutosaveKeep is 0.
+const DefaultAutosaveKeep = 3
+
+// ParseAutosave parses how often to autosave, either a number of turns such as "1000"
+// or a wall-clock duration such as "30s" or "5m". Exactly one of the results is non-zero.
+func ParseAutosave(every string) (int, time.Duration, error) {
+	if turns, err := strconv.Atoi(every); err == nil {
+		if turns < 1 {
+			return 0, 0, fmt.Errorf("invalid autosave interval %q: needs at least 1 turn", every)
+		}
+		return turns, 0, nil
+	}
+	interval, err := time.ParseDuration(every)
+	if err != nil || interval <= 0 {
+		return 0, 0, fmt.Errorf("invalid autosave interval %q: expected a number of turns or a duration such as 30s", every)
+	}
+	return 0, interval, nil
+}
+
+// autosaveFile returns the name of the autosave after the given turn and the file it is written to.
+// It follows Params.OutputName, less any extension, so autosaves sit next to the other images
+// without replacing them, and is always a checkpoint so the run can be resumed from it.
+func autosaveFile(p Params, turn int, now time.Time) (string, string) {
+	name := outputName(p, turn, now)
+	if formatOf(name, "") != "" {
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	name += "-autosave"
+	return name, name + ".golc"
+}
+
+// autosaves remembers the files written by autosave, so that only the newest are kept.
+type autosaves struct {
+	keep  int
+	paths []string
+}
+
+func newAutosaves(p Params) *autosaves {
+	keep := p.AutosaveKeep
+	if keep == 0 {
+		keep = DefaultAutosaveKeep
+	}
+	return &autosaves{keep: keep}
+}
+
+// add records a new autosave and removes the oldest ones beyond the number kept.
+// A file written twice, e.g. when a paused run is autosaved again, is only counted once.
+func (a *autosaves) add(path string) error {
+	for i, old := range a.paths {
+		if old == path {
+			a.paths = append(a.paths[:i], a.paths[i+1:]...)
+			break
+		}
+	}
+	a.paths = append(a.paths, path)
+	for len(a.paths) > a.keep {
+		if err := os.Remove(a.paths[0]); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		a.paths = a.paths[1:]
+	}
+	return nil
+}
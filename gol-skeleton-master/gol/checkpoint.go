@@ -0,0 +1,169 @@
+package gol
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// checkpointMagic starts every checkpoint file, and is followed by the version of the format as one byte.
+const checkpointMagic = "GOLC"
+
+// checkpointVersion is the version of the checkpoint format written, and the only one read.
+const checkpointVersion = 1
+
+// checkpointHeader is everything about a run a checkpoint keeps besides its cells.
+// After the magic number and version it is stored as JSON, preceded by its length as a big endian uint32,
+// and followed by the gzip compressed cells.
+type checkpointHeader struct {
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	Turn     int    `json:"turn"`  // Turns completed by the world in the checkpoint.
+	Turns    int    `json:"turns"` // Turns the run was asked to complete.
+	Rule     string `json:"rule"`
+	Topology string `json:"topology"`
+	Engine   string `json:"engine"`
+	Seed     int64  `json:"seed"`   // Seed of the random world the run started from, 0 if it didn't.
+	Packed   bool   `json:"packed"` // Cells are stored as one bit each, rows padded to a byte, rather than as grey levels.
+}
+
+// writeCheckpoint writes a world and its header as a checkpoint.
+// Worlds with only alive and dead cells are packed eight cells to a byte.
+func writeCheckpoint(w io.Writer, world [][]byte, header checkpointHeader) error {
+	header.Height = len(world)
+	header.Width = 0
+	if header.Height > 0 {
+		header.Width = len(world[0])
+	}
+	header.Packed = true
+	for _, row := range world {
+		for _, cell := range row {
+			header.Packed = header.Packed && (cell == 0 || cell == 255)
+		}
+	}
+	encoded, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(w)
+	out.WriteString(checkpointMagic)
+	out.WriteByte(checkpointVersion)
+	binary.Write(out, binary.BigEndian, uint32(len(encoded)))
+	out.Write(encoded)
+
+	cells := gzip.NewWriter(out)
+	row := make([]byte, (header.Width+7)/8)
+	for _, cellRow := range world {
+		if !header.Packed {
+			cells.Write(cellRow)
+			continue
+		}
+		for i := range row {
+			row[i] = 0
+		}
+		for x, cell := range cellRow {
+			if cell == 255 {
+				row[x/8] |= 0x80 >> uint(x%8)
+			}
+		}
+		cells.Write(row)
+	}
+	if err := cells.Close(); err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+// readCheckpointHeader reads the header at the start of a checkpoint, leaving r at the start of the cells.
+func readCheckpointHeader(r io.Reader) (checkpointHeader, error) {
+	var header checkpointHeader
+	magic := make([]byte, len(checkpointMagic)+1)
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return header, fmt.Errorf("checkpoint: %v", err)
+	}
+	if string(magic[:len(checkpointMagic)]) != checkpointMagic {
+		return header, fmt.Errorf("checkpoint: not a checkpoint file")
+	}
+	if magic[len(checkpointMagic)] != checkpointVersion {
+		return header, fmt.Errorf("checkpoint: unsupported version %d", magic[len(checkpointMagic)])
+	}
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return header, fmt.Errorf("checkpoint: %v", err)
+	}
+	encoded := make([]byte, length)
+	if _, err := io.ReadFull(r, encoded); err != nil {
+		return header, fmt.Errorf("checkpoint: %v", err)
+	}
+	if err := json.Unmarshal(encoded, &header); err != nil {
+		return header, fmt.Errorf("checkpoint: bad header: %v", err)
+	}
+	if header.Width < 1 || header.Height < 1 || header.Turn < 0 {
+		return header, fmt.Errorf("checkpoint: bad size %dx%d or turn %d", header.Width, header.Height, header.Turn)
+	}
+	return header, nil
+}
+
+// readCheckpoint reads a whole checkpoint.
+func readCheckpoint(r io.Reader) (checkpointHeader, [][]byte, error) {
+	in := bufio.NewReader(r)
+	header, err := readCheckpointHeader(in)
+	if err != nil {
+		return header, nil, err
+	}
+	cells, err := gzip.NewReader(in)
+	if err != nil {
+		return header, nil, fmt.Errorf("checkpoint: %v", err)
+	}
+	defer cells.Close()
+
+	world := make([][]byte, header.Height)
+	row := make([]byte, (header.Width+7)/8)
+	for y := range world {
+		world[y] = make([]byte, header.Width)
+		if !header.Packed {
+			if _, err := io.ReadFull(cells, world[y]); err != nil {
+				return header, nil, fmt.Errorf("checkpoint: row %d: %v", y, err)
+			}
+			continue
+		}
+		if _, err := io.ReadFull(cells, row); err != nil {
+			return header, nil, fmt.Errorf("checkpoint: row %d: %v", y, err)
+		}
+		for x := range world[y] {
+			if row[x/8]&(0x80>>uint(x%8)) != 0 {
+				world[y][x] = 255
+			}
+		}
+	}
+	return header, world, nil
+}
+
+// resume returns the parameters for carrying on from the checkpoint at Params.Resume.
+// The size of the world, the rule, the topology, the seed of a soup it started from and the completed turns
+// all come from the checkpoint, as does the engine if Params.Engine is empty. Params.Turns is still the turn to stop at.
+func resume(p Params) (Params, error) {
+	file, err := os.Open(p.Resume)
+	if err != nil {
+		return p, err
+	}
+	defer file.Close()
+	header, err := readCheckpointHeader(bufio.NewReader(file))
+	if err != nil {
+		return p, fmt.Errorf("%v: %v", p.Resume, err)
+	}
+	p.ImageWidth, p.ImageHeight = header.Width, header.Height
+	p.Rule, p.Topology = header.Rule, header.Topology
+	if p.Engine == "" {
+		p.Engine = header.Engine
+	}
+	p.StartTurn = header.Turn
+	// the world comes from the checkpoint rather than a new soup
+	p.Soup, p.Seed = 0, header.Seed
+	return p, nil
+}
@@ -2,6 +2,7 @@ package gol
 import (
 	"fmt"
 	"time"
+	"uk.ac.bris.cs/gameoflife/gol/engine"
 	"uk.ac.bris.cs/gameoflife/util"
 )
 type distributorChannels struct {
@@ -11,50 +12,217 @@ type distributorChannels struct {
 	ioFilename chan<- string
 	ioOutput   chan<- uint8
 	ioInput    <-chan uint8
+	ioError    <-chan error
+	ioSize     <-chan int
+	ioTurn     chan<- int
 	key        <-chan rune
 }
-func distributor(p Params, c distributorChannels) {
+// distributor returns an error if the simulation couldn't start, or if the final image couldn't be written.
+func distributor(p Params, c distributorChannels) error {
+	rule, err := engine.ParseRule(p.Rule)
+	if err != nil {
+		return abort(c, err)
+	}
+	topology, err := engine.ParseTopology(p.Topology)
+	if err != nil {
+		return abort(c, err)
+	}
+	stepper, err := engine.New(p.Engine, rule, topology, p.Threads)
+	if err != nil {
+		return abort(c, err)
+	}
+	// TODO: Read the initial state from the io goroutine.
+	c.ioCommand <- ioInput
+	if p.Resume != "" {
+		c.ioFilename <- p.Resume
+	} else if p.Soup > 0 {
+		c.ioFilename <- soupName(p)
+	} else if p.Stream {
+		c.ioFilename <- "stdin"
+	} else if p.Pattern != "" {
+		c.ioFilename <- p.Pattern
+	} else {
+		c.ioFilename <- fmt.Sprintf("%dx%d", p.ImageWidth, p.ImageHeight)
+	}
+	if err := <-c.ioError; err != nil {
+		c.events <- IOError{CompletedTurns: 0, Err: err}
+		return abort(c, err)
+	}
+	// Params may leave the size of the world to be read from the file, so the io goroutine reports it
+	p.ImageWidth = <-c.ioSize
+	p.ImageHeight = <-c.ioSize
+	c.events <- WorldSize{CompletedTurns: p.StartTurn, Width: p.ImageWidth, Height: p.ImageHeight}
 	// TODO: Create a 2D slice to store the world.
 	world := make([][]byte, p.ImageHeight)
 	for i := range world {
 		world[i] = make([]byte, p.ImageWidth)
 	}
-	// TODO: Read the initial state from the io goroutine.
-	c.ioCommand <- ioInput
-	c.ioFilename <- fmt.Sprintf("%dx%d", p.ImageWidth, p.ImageHeight)
+	initial := CellsUpdated{CompletedTurns: p.StartTurn}
 	for y := 0; y < p.ImageHeight; y++ {
 		for x := 0; x < p.ImageWidth; x++ {
 			val := <-c.ioInput
 			world[y][x] = val
 			if val != 0 {
-				c.events <- CellFlipped{CompletedTurns: 0, Cell: util.Cell{X: x, Y: y}}
+				if rule.Generations() {
+					// Greyscale cells can't be expressed as flips, so report their levels instead
+					initial.Cells = append(initial.Cells, util.Cell{X: x, Y: y})
+					initial.States = append(initial.States, val)
+				} else {
+					c.events <- CellFlipped{CompletedTurns: p.StartTurn, Cell: util.Cell{X: x, Y: y}}
+				}
 			}
 		}
 	}
-	turn := 0
+	if len(initial.Cells) > 0 {
+		c.events <- initial
+	}
+	// The packed engine keeps the world as a BitGrid between turns,
+	// the world of bytes is only rebuilt when an image has to be written.
+	// The hashlife engine jumps many turns at a time and keeps grid as the world at the last jump.
+	// The active engine keeps the world of bytes and only recomputes the tiles that can change.
+	var grid *engine.BitGrid
+	var life *engine.HashLife
+	var region *engine.ActiveRegion
+	switch p.Engine {
+	case "hashlife":
+		life, err = engine.NewHashLife(world, rule, topology)
+		if err != nil {
+			return abort(c, err)
+		}
+	case "active":
+		region = engine.NewActiveRegion(p.ImageWidth, p.ImageHeight, engine.DefaultTileSize, rule, topology)
+	}
+	packed, isPacked := stepper.(*engine.Packed)
+	if life != nil || (isPacked && region == nil) {
+		grid = engine.PackWorld(world)
+		world = nil
+	}
+	aliveCells := func() []util.Cell {
+		if grid != nil {
+			return grid.AliveCells()
+		}
+		return stepper.Alive(world)
+	}
+	aliveCount := func() int {
+		if grid != nil {
+			return grid.Count()
+		}
+		return len(stepper.Alive(world))
+	}
+	snapshot := func() [][]byte {
+		if grid != nil {
+			return grid.Unpack()
+		}
+		return world
+	}
+	// born is the turn each cell last changed state at, so the heat palette can colour alive cells by age
+	var born [][]int
+	if p.Palette == "heat" {
+		born = make([][]int, p.ImageHeight)
+		for i := range born {
+			born[i] = make([]int, p.ImageWidth)
+			for j := range born[i] {
+				born[i][j] = p.StartTurn
+			}
+		}
+	}
+	changed := func(cells []util.Cell, turn int) {
+		if born != nil {
+			for _, cell := range cells {
+				born[cell.Y][cell.X] = turn
+			}
+		}
+	}
+	// A resumed run carries on counting turns from its checkpoint
+	turn := p.StartTurn
+	ages := func() [][]byte {
+		if born == nil {
+			return nil
+		}
+		cells := make([][]byte, p.ImageHeight)
+		for y := range cells {
+			cells[y] = make([]byte, p.ImageWidth)
+			for x := range cells[y] {
+				if age := turn - born[y][x]; age < 255 {
+					cells[y][x] = byte(age)
+				} else {
+					cells[y][x] = 255
+				}
+			}
+		}
+		return cells
+	}
 	c.events <- StateChange{CompletedTurns: turn, NewState: Executing}
 	// Create ticker for periodic reports
 	ticker := time.NewTicker(2 * time.Second)
 	defer ticker.Stop()
 	paused := false
 	// Report initial alive cells
-	c.events <- AliveCellsCount{CompletedTurns: turn, CellsCount: len(calculateAliveCells(p, world))}
+	c.events <- AliveCellsCount{CompletedTurns: turn, CellsCount: aliveCount()}
+	// Autosaves are written by a goroutine of their own so turns carry on meanwhile.
+	// Only one is written at a time, and other images wait for it so they don't interleave on the io channels.
+	saved := newAutosaves(p)
+	var autosaveTicker <-chan time.Time
+	if p.AutosaveInterval > 0 {
+		t := time.NewTicker(p.AutosaveInterval)
+		defer t.Stop()
+		autosaveTicker = t.C
+	}
+	// Turn based autosaves fall on multiples of Params.AutosaveTurns, also when resuming
+	nextAutosave := 0
+	if p.AutosaveTurns > 0 {
+		nextAutosave = turn - turn%p.AutosaveTurns + p.AutosaveTurns
+	}
+	autosaving := false
+	autosaveDone := make(chan bool, 1)
+	autosave := func() bool {
+		if autosaving {
+			return false
+		}
+		autosaving = true
+		world, turn := snapshot(), turn
+		go func() {
+			name, file := autosaveFile(p, turn, time.Now())
+			if sendImage(c, p, world, nil, turn, name, file) == nil {
+				if err := saved.add(outputPath(p, file)); err != nil {
+					c.events <- IOError{CompletedTurns: turn, Err: err}
+				}
+			}
+			autosaveDone <- true
+		}()
+		return true
+	}
+	waitAutosave := func() {
+		if autosaving {
+			<-autosaveDone
+			autosaving = false
+		}
+	}
 	for turn < p.Turns {
 		select {
 		case <-ticker.C:
 			//reports the number of alive cells in the game world during each turn of the Game of Life
 			if !paused {
-				c.events <- AliveCellsCount{CompletedTurns: turn, CellsCount: len(calculateAliveCells(p, world))}
+				c.events <- AliveCellsCount{CompletedTurns: turn, CellsCount: aliveCount()}
 			}
+		case <-autosaveTicker:
+			autosave()
+		case <-autosaveDone:
+			autosaving = false
 		case key := <-c.key:
 			switch key {
 			case 's':
-				outputPGM(c, p, world, turn)
+				waitAutosave()
+				outputImage(c, p, snapshot(), ages(), turn, "")
+			case 'n':
+				waitAutosave()
+				outputImage(c, p, snapshot(), ages(), turn, "png")
 			case 'q':
-				outputPGM(c, p, world, turn)
-				c.events <- FinalTurnComplete{CompletedTurns: turn, Alive: calculateAliveCells(p, world)}
+				waitAutosave()
+				err := outputImage(c, p, snapshot(), ages(), turn, "")
+				c.events <- FinalTurnComplete{CompletedTurns: turn, Alive: aliveCells()}
 				c.events <- StateChange{CompletedTurns: turn, NewState: Quitting}
-				return
+				return err
 			case 'p':
 				paused = !paused
 				if paused {
@@ -64,20 +232,61 @@ func distributor(p Params, c distributorChannels) {
 				}
 			}
 		default:
-			if !paused {
-				newWorld := calculateNextState(p, world)
-				flippedCells := []util.Cell{}
-				// Collect all flipped cells
-				for y := 0; y < p.ImageHeight; y++ {
-					for x := 0; x < p.ImageWidth; x++ {
-						if newWorld[y][x] != world[y][x] {
-							flippedCells = append(flippedCells, util.Cell{X: x, Y: y})
+			if !paused && life != nil {
+				// Jumps stop at autosaves, which would otherwise be jumped over
+				jump := p.Turns - turn
+				if p.AutosaveTurns > 0 && nextAutosave > turn && nextAutosave-turn < jump {
+					jump = nextAutosave - turn
+				}
+				// The flips are reported against the turn they were made from, however far the jump goes
+				from := turn
+				turn += life.Advance(jump)
+				next := life.Grid()
+				if flippedCells := next.Flipped(grid); len(flippedCells) > 0 {
+					changed(flippedCells, turn)
+					c.events <- CellsFlipped{CompletedTurns: from, Cells: flippedCells}
+				}
+				grid = next
+				c.events <- TurnComplete{CompletedTurns: turn}
+			} else if !paused && grid != nil {
+				next := packed.StepGrid(grid, 0, p.ImageHeight)
+				// Flipped cells fall out of XORing the packed words
+				if flippedCells := next.Flipped(grid); len(flippedCells) > 0 {
+					changed(flippedCells, turn+1)
+					c.events <- CellsFlipped{CompletedTurns: turn, Cells: flippedCells}
+				}
+				grid = next
+				turn++
+				c.events <- TurnComplete{CompletedTurns: turn}
+			} else if !paused {
+				var newWorld [][]byte
+				var flippedCells []util.Cell
+				if region != nil {
+					// Only the active tiles are recomputed, and they report their own flips
+					newWorld, flippedCells = region.Step(world)
+				} else {
+					newWorld = stepper.Step(world, 0, p.ImageHeight)
+					// Collect all flipped cells
+					for y := 0; y < p.ImageHeight; y++ {
+						for x := 0; x < p.ImageWidth; x++ {
+							if newWorld[y][x] != world[y][x] {
+								flippedCells = append(flippedCells, util.Cell{X: x, Y: y})
+							}
 						}
 					}
 				}
-				// Send CellsFlipped event for all flipped cells
+				changed(flippedCells, turn+1)
+				states := make([]uint8, len(flippedCells))
+				for i, cell := range flippedCells {
+					states[i] = newWorld[cell.Y][cell.X]
+				}
+				// Send CellsFlipped event for all flipped cells, or CellsUpdated when cells may be in a dying state
 				if len(flippedCells) > 0 {
-					c.events <- CellsFlipped{CompletedTurns: turn, Cells: flippedCells} // Use Cells instead of CellsToFlip
+					if rule.Generations() {
+						c.events <- CellsUpdated{CompletedTurns: turn, Cells: flippedCells, States: states}
+					} else {
+						c.events <- CellsFlipped{CompletedTurns: turn, Cells: flippedCells} // Use Cells instead of CellsToFlip
+					}
 				}
 				// Update the world to the new state
 				world = newWorld
@@ -88,129 +297,63 @@ func distributor(p Params, c distributorChannels) {
 				time.Sleep(10 * time.Millisecond)
 			}
 		}
+		// An autosave still being written is retried on the next turn rather than waited for
+		if p.AutosaveTurns > 0 && turn >= nextAutosave && turn < p.Turns && autosave() {
+			nextAutosave = turn - turn%p.AutosaveTurns + p.AutosaveTurns
+		}
 	}
 	// TODO: Report the final state using FinalTurnCompleteEvent.
 	c.events <- FinalTurnComplete{
 		CompletedTurns: turn,
-		Alive:          calculateAliveCells(p, world),
+		Alive:          aliveCells(),
 	}
 	// TODO: Output the final state as a PGM image.
-	outputPGM(c, p, world, turn)
+	waitAutosave()
+	err = outputImage(c, p, snapshot(), ages(), turn, "")
 	// Make sure that the Io has finished any output before exiting.
 	c.ioCommand <- ioCheckIdle
 	<-c.ioIdle
 	c.events <- StateChange{CompletedTurns: turn, NewState: Quitting}
 	// Close the channel to stop the SDL goroutine gracefully. Removing may cause deadlock.
 	close(c.events)
+	return err
 }
-// outputPGM outputs the world state as a PGM image
-func outputPGM(c distributorChannels, p Params, world [][]byte, turn int) {
+// abort stops the distributor before the first turn, telling the user it is quitting.
+func abort(c distributorChannels, err error) error {
+	c.events <- StateChange{CompletedTurns: 0, NewState: Quitting}
+	close(c.events)
+	return err
+}
+// outputImage outputs the world state as an image in the given format, or Params.Format if that is empty,
+// reporting either an ImageOutputComplete or an IOError event.
+// Ages are only sent for the heat palette, see sendsAges.
+func outputImage(c distributorChannels, p Params, world, ages [][]byte, turn int, format string) error {
+	name := outputName(p, turn, time.Now())
+	return sendImage(c, p, world, ages, turn, name, imageFile(p, name, format))
+}
+// sendImage has the io goroutine write the world to filename, whose extension gives the format,
+// and reports the image as name.
+func sendImage(c distributorChannels, p Params, world, ages [][]byte, turn int, name, filename string) error {
 	c.ioCommand <- ioOutput
-	c.ioFilename <- fmt.Sprintf("%dx%dx%d", p.ImageWidth, p.ImageHeight, turn)
+	c.ioFilename <- filename
+	c.ioTurn <- turn
 	for y := 0; y < p.ImageHeight; y++ {
 		for x := 0; x < p.ImageWidth; x++ {
 			c.ioOutput <- world[y][x]
 		}
 	}
-	c.ioCommand <- ioCheckIdle
-	<-c.ioIdle
-	c.events <- ImageOutputComplete{CompletedTurns: turn, Filename: fmt.Sprintf("%dx%dx%d", p.ImageWidth, p.ImageHeight, turn)}
-}
-// calculateNextState computes the next state of the world using either single or multi-threaded approach
-func calculateNextState(p Params, world [][]byte) [][]byte {
-	newWorld := make([][]byte, p.ImageHeight)
-	for i := range newWorld {
-		newWorld[i] = make([]byte, p.ImageWidth)
-	}
-	// If only one thread is specified, use the single-threaded approach
-	if p.Threads == 1 {
-		return nextState(p, world, 0, p.ImageHeight)
-	} else {
-		// For multi-threaded approach, create channels for each thread
-		chans := make([]chan [][]byte, p.Threads)
-		// Divide the world into strips and process each strip in a separate goroutine
-		for i := 0; i < p.Threads; i++ {
-			chans[i] = make(chan [][]byte)
-			a := i * (p.ImageHeight / p.Threads)
-			b := (i + 1) * (p.ImageHeight / p.Threads)
-			if i == p.Threads-1 {
-				b = p.ImageHeight
-			}
-			// Create a copy of the world to avoid data races
-			worldCopy := copySlice(world)
-			// Start a worker goroutine for this strip
-			go workers(p, worldCopy, chans[i], a, b)
-		}
-		// Collect results from all workers and combine them into the new world
-		for i := 0; i < p.Threads; i++ {
-			strip := <-chans[i]
-			startRow := i * (p.ImageHeight / p.Threads)
-			for r, row := range strip {
-				newWorld[startRow+r] = row
-			}
-		}
-	}
-	return newWorld
-}
-func nextState(p Params, world [][]byte, start, end int) [][]byte {
-	newWorld := make([][]byte, end-start)
-	for i := range newWorld {
-		newWorld[i] = make([]byte, p.ImageWidth)
-	}
-	directions := [8][2]int{
-		{-1, -1}, {-1, 0}, {-1, 1},
-		{0, -1}, {0, 1},
-		{1, -1}, {1, 0}, {1, 1},
-	}
-	for row := start; row < end; row++ {
-		for col := 0; col < p.ImageWidth; col++ {
-			alive := 0
-			for _, dir := range directions {
-				newRow, newCol := (row+dir[0]+p.ImageHeight)%p.ImageHeight, (col+dir[1]+p.ImageWidth)%p.ImageWidth
-				if world[newRow][newCol] == 255 {
-					alive++
-				}
-			}
-			if world[row][col] == 255 {
-				if alive < 2 || alive > 3 {
-					newWorld[row-start][col] = 0
-				} else {
-					newWorld[row-start][col] = 255
-				}
-			} else if world[row][col] == 0 {
-				if alive == 3 {
-					newWorld[row-start][col] = 255
-				} else {
-					newWorld[row-start][col] = 0
-				}
+	if sendsAges(p, formatOf(filename, p.Format)) {
+		for y := 0; y < p.ImageHeight; y++ {
+			for x := 0; x < p.ImageWidth; x++ {
+				c.ioOutput <- ages[y][x]
 			}
 		}
 	}
-	return newWorld
-}
-// workers processes a strip of the world and sends the result back through a channel
-func workers(p Params, world [][]byte, result chan<- [][]byte, start, end int) {
-	worldPiece := nextState(p, world, start, end)
-	result <- worldPiece
-	close(result)
-}
-// copySlice creates a deep copy of a 2D byte slice
-func copySlice(src [][]byte) [][]byte {
-	dst := make([][]byte, len(src))
-	for i := range src {
-		dst[i] = make([]byte, len(src[i]))
-		copy(dst[i], src[i])
-	}
-	return dst
-}
-func calculateAliveCells(p Params, world [][]byte) []util.Cell {
-	var aliveCells []util.Cell
-	for row := 0; row < p.ImageHeight; row++ {
-		for col := 0; col < p.ImageWidth; col++ {
-			if world[row][col] == 255 {
-				aliveCells = append(aliveCells, util.Cell{X: col, Y: row})
-			}
-		}
+	// The io goroutine replies once the file has been written
+	if err := <-c.ioError; err != nil {
+		c.events <- IOError{CompletedTurns: turn, Err: err}
+		return err
 	}
-	return aliveCells
+	c.events <- ImageOutputComplete{CompletedTurns: turn, Filename: name, Path: outputPath(p, filename)}
+	return nil
 }
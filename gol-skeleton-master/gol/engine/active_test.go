@@ -0,0 +1,48 @@
+package engine
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// TestActiveRegion tests that stepping only the active tiles agrees with stepping every cell
+// for each topology and kind of rule, on a world that is mostly empty.
+func TestActiveRegion(t *testing.T) {
+	random := rand.New(rand.NewSource(3))
+	width, height := 90, 70
+	for _, rulestring := range []string{"B3/S23", "B2/S345/C4", "R2,C0,M1,S4..8,B5..6,NM"} {
+		rule := mustParseRule(t, rulestring)
+		for _, name := range []string{"torus", "plane", "klein", "cross", "cylinder-x", "cylinder-y"} {
+			topology := mustParseTopology(t, name)
+			// a random patch straddling the top left corner, so activity crosses the edges
+			world := make([][]byte, height)
+			for y := range world {
+				world[y] = make([]byte, width)
+			}
+			for y := -8; y < 8; y++ {
+				for x := -8; x < 8; x++ {
+					if random.Intn(2) == 0 {
+						world[(y+height)%height][(x+width)%width] = 255
+					}
+				}
+			}
+			region := NewActiveRegion(width, height, 8, rule, topology)
+			expected := world
+			for turn := 0; turn < 30; turn++ {
+				expected = rule.NextRows(expected, 0, height, topology)
+				next, flipped := region.Step(world)
+				for _, cell := range flipped {
+					if next[cell.Y][cell.X] == world[cell.Y][cell.X] {
+						t.Errorf("ERROR: %v %v: cell %v reported as flipped but unchanged", rulestring, name, cell)
+					}
+				}
+				world = next
+				assertEqualWorld(t, fmt.Sprintf("%v %v turn %v", rulestring, name, turn+1), world, expected)
+			}
+			if active := region.Active(); active == (width+7)/8*((height+7)/8) {
+				t.Errorf("ERROR: %v %v: every tile is still active after 30 turns", rulestring, name)
+			}
+		}
+	}
+}
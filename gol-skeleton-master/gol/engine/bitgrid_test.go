@@ -0,0 +1,40 @@
+package engine
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// TestBitGrid checks packing round trips, and that stepping a packed grid whose width is not a multiple of 64
+// agrees with the byte based stepping for every topology.
+func TestBitGrid(t *testing.T) {
+	random := rand.New(rand.NewSource(2))
+	width, height := 100, 37
+	world := make([][]byte, height)
+	alive := 0
+	for y := range world {
+		world[y] = make([]byte, width)
+		for x := range world[y] {
+			if random.Intn(3) == 0 {
+				world[y][x] = 255
+				alive++
+			}
+		}
+	}
+
+	grid := PackWorld(world)
+	if grid.Count() != alive {
+		t.Errorf("ERROR: packed grid has %v alive cells, expected %v", grid.Count(), alive)
+	}
+	assertEqualWorld(t, "round trip", grid.Unpack(), world)
+
+	life := mustParseRule(t, "B3/S23")
+	reference := mustParseRule(t, "R1,C0,M1,S3..4,B3,NM")
+	for _, name := range []string{"torus", "plane", "klein", "cross", "cylinder-x", "cylinder-y"} {
+		topology := mustParseTopology(t, name)
+		for _, threads := range []int{1, 4} {
+			next := grid.Step(life, topology, threads)
+			assertEqualWorld(t, name, next.Unpack(), reference.NextRows(world, 0, height, topology))
+		}
+	}
+}
@@ -0,0 +1,232 @@
+// Package engine holds everything needed to compute turns of the Game of Life: rules, topologies,
+// packed worlds and the engines that step them. It doesn't depend on the rest of gol, so the
+// distributor, the server and the workers can all share one copy of the stepping code.
+package engine
+
+import (
+	"fmt"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// Engine computes the next states of a world, where 255 is alive and anything else is dead or dying.
+// Worlds are never modified in place.
+type Engine interface {
+	// Step returns rows [start, end) of the next state of the world.
+	Step(world [][]byte, start, end int) [][]byte
+	// StepN returns the whole world advanced by the given number of turns.
+	StepN(world [][]byte, turns int) [][]byte
+	// Alive returns the coordinates of the alive cells in the world.
+	Alive(world [][]byte) []util.Cell
+	// Name returns the name the engine is selected by.
+	Name() string
+}
+
+// Names lists every engine that Params.Engine may select. The hashlife and active engines keep state
+// between turns, so they are driven directly by the distributor and server rather than through New.
+var Names = []string{"default", "naive", "parallel", "packed", "hashlife", "active"}
+
+// Check returns an error if name is not one of Names. An empty name means "default".
+func Check(name string) error {
+	if name == "" {
+		return nil
+	}
+	for _, valid := range Names {
+		if name == valid {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid engine %q: expected one of default, naive, parallel, packed, hashlife or active", name)
+}
+
+// New returns the engine with the given name.
+// "default" picks the packed engine when the rule is Packable and the parallel engine otherwise,
+// and so do "hashlife" and "active" when a world has to be stepped one call at a time.
+func New(name string, rule Rule, topology Topology, threads int) (Engine, error) {
+	if err := Check(name); err != nil {
+		return nil, err
+	}
+	if threads < 1 {
+		threads = 1
+	}
+	switch name {
+	case "naive":
+		return &Naive{rule: rule, topology: topology}, nil
+	case "parallel":
+		return &Parallel{Naive{rule: rule, topology: topology}, threads}, nil
+	case "packed":
+		if !rule.Packable() {
+			return nil, fmt.Errorf("packed engine needs a two state rule over the 8 immediate neighbours, not %v", rule)
+		}
+		return &Packed{rule: rule, topology: topology, threads: threads}, nil
+	}
+	if rule.Packable() {
+		return &Packed{rule: rule, topology: topology, threads: threads}, nil
+	}
+	return &Parallel{Naive{rule: rule, topology: topology}, threads}, nil
+}
+
+// Naive visits the 8 neighbours of every cell in a single goroutine.
+// Larger than Life rules are counted with prefix sums instead.
+type Naive struct {
+	rule     Rule
+	topology Topology
+}
+
+func (e *Naive) Name() string {
+	return "naive"
+}
+
+func (e *Naive) Step(world [][]byte, start, end int) [][]byte {
+	if e.rule.LargerThanLife() {
+		return e.rule.NextRows(world, start, end, e.topology)
+	}
+	height := len(world)
+	width := len(world[0])
+	nextWorld := make([][]byte, end-start)
+	for i := range nextWorld {
+		nextWorld[i] = make([]byte, width)
+	}
+	directions := [8][2]int{
+		{-1, -1}, {-1, 0}, {-1, 1},
+		{0, -1}, {0, 1},
+		{1, -1}, {1, 0}, {1, 1},
+	}
+	for row := start; row < end; row++ {
+		for col := 0; col < width; col++ {
+			alive := 0
+			for _, dir := range directions {
+				newRow, newCol := row+dir[0], col+dir[1]
+				// only neighbours across an edge depend on the topology
+				if newRow < 0 || newRow >= height || newCol < 0 || newCol >= width {
+					var ok bool
+					newRow, newCol, ok = e.topology.Wrap(newRow, newCol, height, width)
+					if !ok {
+						continue
+					}
+				}
+				if world[newRow][newCol] == 255 {
+					alive++
+				}
+			}
+			nextWorld[row-start][col] = e.rule.Next(world[row][col], alive)
+		}
+	}
+	return nextWorld
+}
+
+func (e *Naive) StepN(world [][]byte, turns int) [][]byte {
+	return stepN(e, world, turns)
+}
+
+func (e *Naive) Alive(world [][]byte) []util.Cell {
+	return aliveCells(world)
+}
+
+// Parallel splits the rows into one strip per thread and steps each strip naively in its own goroutine.
+type Parallel struct {
+	Naive
+	threads int
+}
+
+func (e *Parallel) Name() string {
+	return "parallel"
+}
+
+func (e *Parallel) Step(world [][]byte, start, end int) [][]byte {
+	height := end - start
+	threads := e.threads
+	if threads > height {
+		threads = 1
+	}
+	if threads == 1 {
+		return e.Naive.Step(world, start, end)
+	}
+	nextWorld := make([][]byte, height)
+	done := make(chan bool)
+	for i := 0; i < threads; i++ {
+		a := start + i*(height/threads)
+		b := start + (i+1)*(height/threads)
+		if i == threads-1 {
+			b = end
+		}
+		go func(a, b int) {
+			copy(nextWorld[a-start:], e.Naive.Step(world, a, b))
+			done <- true
+		}(a, b)
+	}
+	for i := 0; i < threads; i++ {
+		<-done
+	}
+	return nextWorld
+}
+
+func (e *Parallel) StepN(world [][]byte, turns int) [][]byte {
+	return stepN(e, world, turns)
+}
+
+// Packed steps a BitGrid 64 cells at a time, splitting the rows between the threads.
+// Worlds of bytes are packed on the way in and unpacked on the way out,
+// so callers that keep a BitGrid between turns should use StepGrid.
+type Packed struct {
+	rule     Rule
+	topology Topology
+	threads  int
+}
+
+func (e *Packed) Name() string {
+	return "packed"
+}
+
+func (e *Packed) Step(world [][]byte, start, end int) [][]byte {
+	return e.StepGrid(PackWorld(world), start, end).Unpack()
+}
+
+// StepGrid returns rows [start, end) of the next generation of a packed world as a grid of their own.
+func (e *Packed) StepGrid(grid *BitGrid, start, end int) *BitGrid {
+	next := NewBitGrid(grid.Width, grid.Height)
+	grid.stepParallel(next, e.rule, e.topology, start, end, e.threads)
+	return next.Rows(start, end)
+}
+
+func (e *Packed) StepN(world [][]byte, turns int) [][]byte {
+	grid := PackWorld(world)
+	for turn := 0; turn < turns; turn++ {
+		grid = grid.Step(e.rule, e.topology, e.threads)
+	}
+	return grid.Unpack()
+}
+
+func (e *Packed) Alive(world [][]byte) []util.Cell {
+	return aliveCells(world)
+}
+
+// stepN applies e.Step to the whole world the given number of times.
+func stepN(e Engine, world [][]byte, turns int) [][]byte {
+	for turn := 0; turn < turns; turn++ {
+		world = e.Step(world, 0, len(world))
+	}
+	return world
+}
+
+func aliveCells(world [][]byte) []util.Cell {
+	var cells []util.Cell
+	for y, row := range world {
+		for x, cell := range row {
+			if cell == 255 {
+				cells = append(cells, util.Cell{X: x, Y: y})
+			}
+		}
+	}
+	return cells
+}
+
+// copySlice creates a deep copy of a 2D byte slice
+func copySlice(src [][]byte) [][]byte {
+	dst := make([][]byte, len(src))
+	for i := range src {
+		dst[i] = make([]byte, len(src[i]))
+		copy(dst[i], src[i])
+	}
+	return dst
+}
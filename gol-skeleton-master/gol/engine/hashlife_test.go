@@ -0,0 +1,50 @@
+package engine
+
+import "testing"
+
+// TestHashLifeBirthOnZero tests that rules giving birth on 0 neighbours are refused, as hashlife takes empty space to stay empty.
+func TestHashLifeBirthOnZero(t *testing.T) {
+	world := make([][]byte, 16)
+	for i := range world {
+		world[i] = make([]byte, 16)
+	}
+	for _, s := range []string{"B0/S8", "B0123478/S34678"} {
+		rule, err := ParseRule(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewHashLife(world, rule, Torus); err == nil {
+			t.Errorf("ERROR: hashlife accepted %v", s)
+		}
+	}
+	life, err := ParseRule(DefaultRule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewHashLife(world, life, Torus); err != nil {
+		t.Errorf("ERROR: hashlife refused Life: %v", err)
+	}
+}
+
+// TestHashLifeSteadyState tests that the 512x512 image reaches its steady state of 5565/5567 alive cells
+// after 10^10 turns, which only jumping whole periods at a time makes possible.
+func TestHashLifeSteadyState(t *testing.T) {
+	world := readWorld(t, "../../images/512x512.pgm", 512, 512)
+	life, err := NewHashLife(world, mustParseRule(t, DefaultRule), Torus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	turn := 0
+	for _, turns := range []int{10000000000, 10000000001} {
+		for turn < turns {
+			turn += life.Advance(turns - turn)
+		}
+		expected := 5565
+		if turns%2 == 1 {
+			expected = 5567
+		}
+		if alive := life.Count(); alive != expected {
+			t.Errorf("ERROR: At turn %v expected %v alive cells, got %v instead", turns, expected, alive)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package engine
+
+// NextRows computes rows [start, end) of the next generation of a world with the given topology under the rule.
+// It is meant for Larger than Life rules, where checking every neighbour of every cell would cost O(R²).
+func (r Rule) NextRows(world [][]byte, start, end int, topology Topology) [][]byte {
+	return r.NextPadded(topology.Pad(world, start, end, r.Radius), r.Radius)
+}
+
+// NextPadded computes the next generation of the inner cells of a segment padded by halo rows and columns
+// on every side, as produced by Topology.Pad. The halo must be at least the rule's radius.
+// Instead of visiting every neighbour, the segment is summed once: a summed-area table gives
+// each Moore count in O(1), and per-row prefix sums give each von Neumann count in O(R).
+func (r Rule) NextPadded(segment [][]byte, halo int) [][]byte {
+	radius := r.Radius
+	paddedHeight := len(segment)
+	paddedWidth := len(segment[0])
+	rows := paddedHeight - 2*halo
+	width := paddedWidth - 2*halo
+
+	alive := make([][]int, paddedHeight)
+	for i := range alive {
+		alive[i] = make([]int, paddedWidth)
+		for j, cell := range segment[i] {
+			if cell == 255 {
+				alive[i][j] = 1
+			}
+		}
+	}
+
+	// count returns the neighbours of the cell at (row, col) in segment coordinates
+	var count func(row, col int) int
+	if r.Shape == VonNeumann {
+		// prefix[i][j] is the number of alive cells in alive[i][0:j]
+		prefix := make([][]int, paddedHeight)
+		for i := range prefix {
+			prefix[i] = make([]int, paddedWidth+1)
+			for j := 0; j < paddedWidth; j++ {
+				prefix[i][j+1] = prefix[i][j] + alive[i][j]
+			}
+		}
+		count = func(row, col int) int {
+			total := 0
+			for dy := -radius; dy <= radius; dy++ {
+				reach := radius - abs(dy)
+				line := prefix[row+dy]
+				total += line[col+reach+1] - line[col-reach]
+			}
+			return total
+		}
+	} else {
+		// area[i][j] is the number of alive cells in alive[0:i][0:j]
+		area := make([][]int, paddedHeight+1)
+		area[0] = make([]int, paddedWidth+1)
+		for i := 0; i < paddedHeight; i++ {
+			area[i+1] = make([]int, paddedWidth+1)
+			for j := 0; j < paddedWidth; j++ {
+				area[i+1][j+1] = alive[i][j] + area[i][j+1] + area[i+1][j] - area[i][j]
+			}
+		}
+		count = func(row, col int) int {
+			top, left, bottom, right := row-radius, col-radius, row+radius+1, col+radius+1
+			return area[bottom][right] - area[top][right] - area[bottom][left] + area[top][left]
+		}
+	}
+
+	nextWorld := make([][]byte, rows)
+	for row := 0; row < rows; row++ {
+		nextWorld[row] = make([]byte, width)
+		for col := 0; col < width; col++ {
+			neighbours := count(row+halo, col+halo)
+			if !r.Middle {
+				neighbours -= alive[row+halo][col+halo]
+			}
+			nextWorld[row][col] = r.Next(segment[row+halo][col+halo], neighbours)
+		}
+	}
+	return nextWorld
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
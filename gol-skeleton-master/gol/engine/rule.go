@@ -0,0 +1,335 @@
+package engine
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultRule is the rulestring for Conway's Game of Life.
+// It is used whenever Params.Rule is left empty.
+const DefaultRule = "B3/S23"
+
+// maxRadius bounds the neighbourhood radius of Larger than Life rules.
+const maxRadius = 100
+
+// Neighbourhood is the shape of the cells counted as neighbours.
+type Neighbourhood int
+
+const (
+	Moore      Neighbourhood = iota // the (2R+1)x(2R+1) square around a cell
+	VonNeumann                      // the cells within Manhattan distance R of a cell
+)
+
+// Rule is a parsed birth/survival rulestring.
+// Birth[n] is true if a dead cell with n alive neighbours becomes alive,
+// Survival[n] is true if an alive cell with n alive neighbours stays alive.
+// States is the number of cell states of a Generations rule; 2 means a plain alive/dead rule.
+// Radius, Shape and Middle describe the neighbourhood: B/S rules use the radius 1 Moore neighbourhood
+// without the middle cell, Larger than Life rules may use a bigger radius, either shape and count the middle cell.
+type Rule struct {
+	Birth    []bool
+	Survival []bool
+	States   int
+	Radius   int
+	Shape    Neighbourhood
+	Middle   bool
+}
+
+// ParseRule parses a rulestring in B/S notation, e.g. "B3/S23" (Life), "B36/S23" (HighLife) or "B2/S" (Seeds).
+// The B and S parts may appear in either order and are case-insensitive.
+// A trailing "/C<n>" part makes it a Generations rule with n states, e.g. "B2/S345/C4" (Star Wars).
+// Larger than Life rules use the comma separated notation, e.g. "R5,C0,M1,S34..58,B34..45,NM" (Bosco's Rule).
+// An empty string is treated as DefaultRule.
+func ParseRule(s string) (Rule, error) {
+	if s == "" {
+		s = DefaultRule
+	}
+	upper := strings.ToUpper(strings.TrimSpace(s))
+	if strings.HasPrefix(upper, "R") {
+		return parseLargerThanLife(s, upper)
+	}
+
+	rule := Rule{
+		Birth:    make([]bool, 9),
+		Survival: make([]bool, 9),
+		States:   2,
+		Radius:   1,
+		Shape:    Moore,
+	}
+	parts := strings.Split(upper, "/")
+	if len(parts) == 3 {
+		states, err := strconv.Atoi(strings.TrimPrefix(parts[2], "C"))
+		if !strings.HasPrefix(parts[2], "C") || err != nil || states < 2 || states > 256 {
+			return rule, fmt.Errorf("invalid rule %q: expected a state count C2 to C256", s)
+		}
+		rule.States = states
+		parts = parts[:2]
+	}
+	if len(parts) != 2 {
+		return rule, fmt.Errorf("invalid rule %q: expected the form B.../S... or B.../S.../C...", s)
+	}
+
+	seenBirth, seenSurvival := false, false
+	for _, part := range parts {
+		if part == "" {
+			return rule, fmt.Errorf("invalid rule %q: empty part", s)
+		}
+		var counts []bool
+		switch part[0] {
+		case 'B':
+			if seenBirth {
+				return rule, fmt.Errorf("invalid rule %q: B given twice", s)
+			}
+			seenBirth = true
+			counts = rule.Birth
+		case 'S':
+			if seenSurvival {
+				return rule, fmt.Errorf("invalid rule %q: S given twice", s)
+			}
+			seenSurvival = true
+			counts = rule.Survival
+		default:
+			return rule, fmt.Errorf("invalid rule %q: unexpected %q", s, part[0])
+		}
+		for _, digit := range part[1:] {
+			if digit < '0' || digit > '8' {
+				return rule, fmt.Errorf("invalid rule %q: neighbour count %q out of range", s, digit)
+			}
+			counts[digit-'0'] = true
+		}
+	}
+	return rule, nil
+}
+
+// parseLargerThanLife parses the R,C,M,S,B,N fields of a Larger than Life rulestring.
+func parseLargerThanLife(s, upper string) (Rule, error) {
+	rule := Rule{States: 2, Shape: Moore}
+	var birth, survival []string
+	for _, field := range strings.Split(upper, ",") {
+		if len(field) < 2 {
+			return rule, fmt.Errorf("invalid rule %q: malformed field %q", s, field)
+		}
+		value := field[1:]
+		switch field[0] {
+		case 'R':
+			radius, err := strconv.Atoi(value)
+			if err != nil || radius < 1 || radius > maxRadius {
+				return rule, fmt.Errorf("invalid rule %q: radius must be between 1 and %d", s, maxRadius)
+			}
+			rule.Radius = radius
+		case 'C':
+			states, err := strconv.Atoi(value)
+			if err != nil || states < 0 || states == 1 || states > 256 {
+				return rule, fmt.Errorf("invalid rule %q: expected a state count of 0 or 2 to 256", s)
+			}
+			if states > 2 {
+				rule.States = states
+			}
+		case 'M':
+			if value != "0" && value != "1" {
+				return rule, fmt.Errorf("invalid rule %q: M must be 0 or 1", s)
+			}
+			rule.Middle = value == "1"
+		case 'S':
+			survival = append(survival, value)
+		case 'B':
+			birth = append(birth, value)
+		case 'N':
+			switch value {
+			case "M":
+				rule.Shape = Moore
+			case "N":
+				rule.Shape = VonNeumann
+			default:
+				return rule, fmt.Errorf("invalid rule %q: unsupported neighbourhood %q", s, value)
+			}
+		default:
+			return rule, fmt.Errorf("invalid rule %q: unexpected field %q", s, field)
+		}
+	}
+	if rule.Radius == 0 {
+		return rule, fmt.Errorf("invalid rule %q: missing radius", s)
+	}
+
+	size := rule.NeighbourhoodSize() + 1
+	rule.Birth = make([]bool, size)
+	rule.Survival = make([]bool, size)
+	for _, r := range []struct {
+		ranges []string
+		counts []bool
+	}{{birth, rule.Birth}, {survival, rule.Survival}} {
+		for _, countRange := range r.ranges {
+			low, high, err := parseRange(countRange)
+			if err != nil || low > high || high >= size {
+				return rule, fmt.Errorf("invalid rule %q: bad count range %q", s, countRange)
+			}
+			for n := low; n <= high; n++ {
+				r.counts[n] = true
+			}
+		}
+	}
+	return rule, nil
+}
+
+// parseRange parses either a single count "n" or an inclusive range "a..b".
+func parseRange(s string) (int, int, error) {
+	bounds := strings.SplitN(s, "..", 2)
+	low, err := strconv.Atoi(bounds[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(bounds) == 1 {
+		return low, low, nil
+	}
+	high, err := strconv.Atoi(bounds[1])
+	return low, high, err
+}
+
+// Generations reports whether the rule has dying states between alive and dead.
+func (r Rule) Generations() bool {
+	return r.States > 2
+}
+
+// LargerThanLife reports whether the rule needs more than the 8 immediate neighbours.
+func (r Rule) LargerThanLife() bool {
+	return r.Radius > 1 || r.Shape != Moore || r.Middle
+}
+
+// NeighbourhoodSize returns the largest possible number of alive neighbours under the rule.
+func (r Rule) NeighbourhoodSize() int {
+	var size int
+	if r.Shape == VonNeumann {
+		size = 2*r.Radius*(r.Radius+1) + 1
+	} else {
+		size = (2*r.Radius + 1) * (2*r.Radius + 1)
+	}
+	if !r.Middle {
+		size--
+	}
+	return size
+}
+
+// Next returns the next value of a cell given its current value and its number of alive neighbours.
+// Only fully alive (255) cells should be counted as alive neighbours.
+// Under a Generations rule, a cell that does not survive fades through the dying states before becoming dead.
+func (r Rule) Next(cell byte, alive int) byte {
+	if !r.Generations() {
+		if cell == 255 {
+			if r.Survival[alive] {
+				return 255
+			}
+			return 0
+		}
+		if r.Birth[alive] {
+			return 255
+		}
+		return 0
+	}
+
+	switch state := r.State(cell); state {
+	case 0:
+		if r.Birth[alive] {
+			return 255
+		}
+		return 0
+	case 1:
+		if r.Survival[alive] {
+			return 255
+		}
+		return r.Grey(2)
+	default:
+		return r.Grey(state + 1)
+	}
+}
+
+// Grey maps a Generations state to the grey level stored in the world.
+// State 0 is dead (0), state 1 is alive (255) and the dying states fade evenly towards 0.
+func (r Rule) Grey(state int) byte {
+	if state <= 0 || state >= r.States {
+		return 0
+	}
+	if state == 1 {
+		return 255
+	}
+	return byte(255 * (r.States - state) / (r.States - 1))
+}
+
+// State maps a grey level from the world back to its Generations state.
+func (r Rule) State(grey byte) int {
+	switch grey {
+	case 0:
+		return 0
+	case 255:
+		return 1
+	}
+	state := r.States - (int(grey)*(r.States-1)+127)/255
+	if state < 2 {
+		state = 2
+	}
+	return state
+}
+
+// String formats the rule back into canonical B/S or Larger than Life notation.
+func (r Rule) String() string {
+	if r.LargerThanLife() {
+		return r.largerThanLifeString()
+	}
+
+	var b strings.Builder
+	b.WriteString("B")
+	for n, born := range r.Birth {
+		if born {
+			b.WriteByte(byte('0' + n))
+		}
+	}
+	b.WriteString("/S")
+	for n, survives := range r.Survival {
+		if survives {
+			b.WriteByte(byte('0' + n))
+		}
+	}
+	if r.Generations() {
+		b.WriteString("/C" + strconv.Itoa(r.States))
+	}
+	return b.String()
+}
+
+func (r Rule) largerThanLifeString() string {
+	states, middle, shape := 0, 0, "M"
+	if r.Generations() {
+		states = r.States
+	}
+	if r.Middle {
+		middle = 1
+	}
+	if r.Shape == VonNeumann {
+		shape = "N"
+	}
+	fields := []string{fmt.Sprintf("R%d", r.Radius), fmt.Sprintf("C%d", states), fmt.Sprintf("M%d", middle)}
+	fields = append(fields, countRanges("S", r.Survival)...)
+	fields = append(fields, countRanges("B", r.Birth)...)
+	fields = append(fields, "N"+shape)
+	return strings.Join(fields, ",")
+}
+
+// countRanges formats each run of true counts as a field, e.g. "S34..58".
+func countRanges(prefix string, counts []bool) []string {
+	var fields []string
+	for low := 0; low < len(counts); low++ {
+		if !counts[low] {
+			continue
+		}
+		high := low
+		for high+1 < len(counts) && counts[high+1] {
+			high++
+		}
+		if low == high {
+			fields = append(fields, fmt.Sprintf("%s%d", prefix, low))
+		} else {
+			fields = append(fields, fmt.Sprintf("%s%d..%d", prefix, low, high))
+		}
+		low = high
+	}
+	return fields
+}
@@ -0,0 +1,79 @@
+package engine
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// TestParseRule checks that rulestrings are parsed into the expected birth and survival counts.
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		rule     string
+		expected string
+	}{
+		{"", "B3/S23"},
+		{"B3/S23", "B3/S23"},
+		{"b36/s23", "B36/S23"},
+		{"S23/B36", "B36/S23"},
+		{"B2/S", "B2/S"},
+		{"B2/S345/C4", "B2/S345/C4"},
+		{"B2/S/C2", "B2/S"},
+		{"R5,C0,M1,S34..58,B34..45,NM", "R5,C0,M1,S34..58,B34..45,NM"},
+		{"r2,c3,m0,b3..4,s2..5,s8,nn", "R2,C3,M0,S2..5,S8,B3..4,NN"},
+	}
+	for _, test := range tests {
+		rule, err := ParseRule(test.rule)
+		if err != nil {
+			t.Errorf("ERROR: %q should be a valid rule, got %v", test.rule, err)
+			continue
+		}
+		if rule.String() != test.expected {
+			t.Errorf("ERROR: %q parsed as %v, expected %v", test.rule, rule, test.expected)
+		}
+	}
+
+	for _, invalid := range []string{"B3", "B9/S23", "X3/S23", "B3/B3", "B3/S23/S2", "B2/S/C1", "B2/S/X4", "R0,B1,S1", "R1,B3..12,S2", "R2,B1,S1,NX"} {
+		if _, err := ParseRule(invalid); err == nil {
+			t.Errorf("ERROR: %q should not be a valid rule", invalid)
+		}
+	}
+}
+
+// TestLargerThanLife checks that the prefix sum counting of NextRows agrees with counting every neighbour.
+func TestLargerThanLife(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	world := make([][]byte, 40)
+	for y := range world {
+		world[y] = make([]byte, 30)
+		for x := range world[y] {
+			if random.Intn(3) == 0 {
+				world[y][x] = 255
+			}
+		}
+	}
+	for _, rulestring := range []string{"R2,C0,M1,S6..12,B5..9,NM", "R3,C0,M0,S4..9,B5..7,NN"} {
+		rule := mustParseRule(t, rulestring)
+		next := rule.NextRows(world, 5, 35, Torus)
+		for y := 5; y < 35; y++ {
+			for x := 0; x < 30; x++ {
+				alive := 0
+				for dy := -rule.Radius; dy <= rule.Radius; dy++ {
+					for dx := -rule.Radius; dx <= rule.Radius; dx++ {
+						if rule.Shape == VonNeumann && abs(dx)+abs(dy) > rule.Radius {
+							continue
+						}
+						if dx == 0 && dy == 0 && !rule.Middle {
+							continue
+						}
+						if world[(y+dy+40)%40][(x+dx+30)%30] == 255 {
+							alive++
+						}
+					}
+				}
+				if expected := rule.Next(world[y][x], alive); next[y-5][x] != expected {
+					t.Fatalf("ERROR: %v at (%v, %v) expected %v, got %v", rulestring, x, y, expected, next[y-5][x])
+				}
+			}
+		}
+	}
+}
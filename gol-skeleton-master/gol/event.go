@@ -24,9 +24,11 @@ type AliveCellsCount struct { // implements Event
 
 // `ImageOutputComplete` is an Event notifying the user about the completion of output.
 // This Event should be sent every time an image has been saved.
+// Filename is the name given to the image, and Path the file actually written, with its extension.
 type ImageOutputComplete struct { // implements Event
 	CompletedTurns int
 	Filename       string
+	Path           string
 }
 
 // State represents a change in the state of execution.
@@ -45,6 +47,15 @@ type StateChange struct { // implements Event
 	NewState       State
 }
 
+// `WorldSize` is an Event notifying the GUI and the testing framework about the size of the world.
+// This Event is sent once the initial image has been read, before any `CellFlipped`.
+// It matters when Params.ImageWidth and Params.ImageHeight are 0, so the size is read from the input file.
+type WorldSize struct { // implements Event
+	CompletedTurns int
+	Width          int
+	Height         int
+}
+
 // `CellFlipped` is an Event notifying the GUI about a change of state of a single cell.
 // This event should be sent every time a cell changes state.
 // Make sure to send this event for all cells that are alive when the image is loaded in.
@@ -63,6 +74,16 @@ type CellsFlipped struct { // implements Event
 	Cells          []util.Cell
 }
 
+// `CellsUpdated` is an Event notifying the GUI about cells whose new state is not a plain flip,
+// such as the dying states of a Generations rule.
+// States[i] is the new grey level of Cells[i], where 0 is dead and 255 is alive.
+// Like `CellsFlipped`, it must be sent *before* the `TurnComplete` of its turn.
+type CellsUpdated struct { // implements Event
+	CompletedTurns int
+	Cells          []util.Cell
+	States         []uint8
+}
+
 // `TurnComplete` is an Event notifying the GUI about turn completion.
 // SDL will render a frame when this event is sent.
 // All `CellFlipped` or `CellsFlipped` events must be sent *before* `TurnComplete`.
@@ -70,6 +91,14 @@ type TurnComplete struct { // implements Event
 	CompletedTurns int
 }
 
+// `IOError` is an Event notifying the user that an image could not be read or written.
+// If the initial image can't be read, it is followed by a `StateChange` to Quitting and nothing else.
+// A failed output leaves the simulation running, except at the end, when `Run` returns the error.
+type IOError struct { // implements Event
+	CompletedTurns int
+	Err            error
+}
+
 // `FinalTurnComplete` is an Event notifying the testing framework about the new world state after execution finished.
 // The data included with this Event is used directly by the tests.
 // SDL closes the window when this Event is sent.
@@ -110,6 +139,9 @@ func (event AliveCellsCount) GetCompletedTurns() int {
 }
 
 func (event ImageOutputComplete) String() string {
+	if event.Path != "" {
+		return fmt.Sprintf("File %v Output Done", event.Path)
+	}
 	return fmt.Sprintf("File %v Output Done", event.Filename)
 }
 
@@ -117,6 +149,14 @@ func (event ImageOutputComplete) GetCompletedTurns() int {
 	return event.CompletedTurns
 }
 
+func (event WorldSize) String() string {
+	return fmt.Sprintf("World %vx%v", event.Width, event.Height)
+}
+
+func (event WorldSize) GetCompletedTurns() int {
+	return event.CompletedTurns
+}
+
 func (event CellFlipped) String() string {
 	return ""
 }
@@ -133,6 +173,14 @@ func (event CellsFlipped) GetCompletedTurns() int {
 	return event.CompletedTurns
 }
 
+func (event CellsUpdated) String() string {
+	return ""
+}
+
+func (event CellsUpdated) GetCompletedTurns() int {
+	return event.CompletedTurns
+}
+
 func (event TurnComplete) String() string {
 	return ""
 }
@@ -141,6 +189,14 @@ func (event TurnComplete) GetCompletedTurns() int {
 	return event.CompletedTurns
 }
 
+func (event IOError) String() string {
+	return fmt.Sprintf("IO Error: %v", event.Err)
+}
+
+func (event IOError) GetCompletedTurns() int {
+	return event.CompletedTurns
+}
+
 func (event FinalTurnComplete) String() string {
 	return "Final Turn Complete"
 }
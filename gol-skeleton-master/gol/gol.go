@@ -1,15 +1,190 @@
 package gol
 
+import (
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
 // Params provides the details of how to run the Game of Life and which image to load.
 type Params struct {
 	Turns       int
 	Threads     int
-	ImageWidth  int
+	ImageWidth  int // Width of the world. If it or ImageHeight is 0, the size is read from the input file.
 	ImageHeight int
+	Rule        string // Birth/survival rulestring, e.g. "B36/S23". Empty means engine.DefaultRule.
+	Topology    string // How the edges are joined, e.g. "klein". Empty means a torus.
+	Engine      string // How turns are computed, one of engine.Names. Empty means "default".
+
+	Pattern       string     // Path of an .rle, .cells, Life 1.06 .lif or netpbm pattern to start from instead of WxH.pgm.
+	PatternOffset *util.Cell // Where the top left corner of the pattern, or the origin of a .lif, goes. Nil centres it.
+	Format        string     // Format of the images written out: "pgm", "rle", "cells", "lif", "png" or "golc". Empty means "pgm".
+	Palette       string     // Colours of PNG images: "classic", "inverted" or "heat". Empty means "classic".
+	Scale         int        // Width and height in pixels of each cell of PNG images. 0 means 1.
+
+	Soup     float64         // Chance of each cell being alive in a random soup to start from instead of WxH.pgm. 0 means no soup.
+	Seed     int64           // Seed of the random soup, recorded in output filenames and checkpoints unless it is 0.
+	SoupArea image.Rectangle // Part of the world filled by the soup, the rest is dead. Empty means the whole world.
+
+	InputDir   string // Directory WxH.pgm is read from. Empty means "images".
+	OutputDir  string // Directory images are written to, created if missing. Empty means "out".
+	OutputName string // Filename template of the images written out, see CheckOutputName. Empty means DefaultOutputName.
+	Stream     bool   // Read the initial world from stdin and write images to stdout instead, see CheckStreamFormat.
+
+	Resume    string // Path of a .golc checkpoint to carry on from instead of starting a new world.
+	StartTurn int    // Turns completed by the initial world. Run sets it from the checkpoint when resuming.
+
+	AutosaveTurns    int           // Turns between checkpoints written in the background, see ParseAutosave. 0 means none.
+	AutosaveInterval time.Duration // Wall-clock time between checkpoints written in the background. 0 means none.
+	AutosaveKeep     int           // Number of the newest autosaves kept. 0 means DefaultAutosaveKeep.
+}
+
+// DefaultOutputName is the filename template used when Params.OutputName is empty.
+const DefaultOutputName = "{w}x{h}x{turn}"
+
+// DefaultSoupOutputName is used instead of DefaultOutputName by runs from a random soup, so they can be told apart.
+const DefaultSoupOutputName = "{w}x{h}x{turn}-s{seed}"
+
+// outputNameFields are the placeholders a filename template may use.
+var outputNameFields = []string{"w", "h", "turn", "ts", "seed", "pid"}
+
+// formats lists every valid Params.Format, which is also the extension of the files written in it.
+// A "golc" checkpoint keeps everything needed to carry on the run with Params.Resume.
+var formats = []string{"pgm", "rle", "cells", "lif", "png", "golc"}
+
+// CheckFormat returns an error if name is not a valid Params.Format.
+func CheckFormat(name string) error {
+	if name == "" || formatOf("."+name, "") != "" {
+		return nil
+	}
+	return fmt.Errorf("invalid format %q: expected pgm, rle, cells, lif, png or golc", name)
+}
+
+// formatOf returns the format a file is written in according to its extension, or fallback if that isn't one of formats.
+func formatOf(filename, fallback string) string {
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(filename)), ".")
+	for _, format := range formats {
+		if ext == format {
+			return format
+		}
+	}
+	return fallback
+}
+
+// CheckPalette returns an error if name is not a valid Params.Palette.
+func CheckPalette(name string) error {
+	switch name {
+	case "", "classic", "inverted", "heat":
+		return nil
+	}
+	return fmt.Errorf("invalid palette %q: expected classic, inverted or heat", name)
+}
+
+// CheckOutputName returns an error if template is not a valid Params.OutputName.
+// Templates may contain {w} and {h} for the size of the world, {turn} for the completed turns,
+// {ts} for the time the image was written to the millisecond, {seed} for Params.Seed and {pid} for the process
+// writing it, e.g. "{w}x{h}-t{turn}-{ts}.pgm". Runs in parallel can use {pid} to keep from overwriting each other.
+// Names without the extension of a format are given the one of the format written,
+// and the extension of names with one is replaced when Params.Format or the key pressed asks for another format.
+// ImageOutputComplete reports the name as filled in, and the file written as its Path.
+func CheckOutputName(template string) error {
+	rest := template
+	for {
+		open := strings.IndexAny(rest, "{}")
+		if open < 0 {
+			break
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if rest[open] == '}' || end < 0 {
+			return fmt.Errorf("invalid output name %q: unbalanced braces", template)
+		}
+		field := rest[open+1 : open+end]
+		valid := false
+		for _, name := range outputNameFields {
+			valid = valid || field == name
+		}
+		if !valid {
+			return fmt.Errorf("invalid output name %q: unknown field {%v}, expected {w}, {h}, {turn}, {ts}, {seed} or {pid}", template, field)
+		}
+		rest = rest[open+end+1:]
+	}
+	if strings.ContainsAny(template, `/\`) {
+		return fmt.Errorf("invalid output name %q: use the output directory instead of a path", template)
+	}
+	return nil
+}
+
+// outputName fills in a filename template for an image of the world after the given turn.
+func outputName(p Params, turn int, now time.Time) string {
+	template := p.OutputName
+	if template == "" {
+		template = DefaultOutputName
+	}
+	if template == DefaultOutputName && p.Seed != 0 {
+		template = DefaultSoupOutputName
+	}
+	return strings.NewReplacer(
+		"{w}", strconv.Itoa(p.ImageWidth),
+		"{h}", strconv.Itoa(p.ImageHeight),
+		"{turn}", strconv.Itoa(turn),
+		"{ts}", now.Format("20060102-150405.000"),
+		"{seed}", strconv.FormatInt(p.Seed, 10),
+		"{pid}", strconv.Itoa(os.Getpid()),
+	).Replace(template)
+}
+
+// imageFile returns the name of the file an image is written to, which is name with the extension of format.
+// An empty format means Params.Format. When neither is given, an extension of a format in name is kept,
+// otherwise it is replaced, so a requested format always wins over the one in the template.
+func imageFile(p Params, name, format string) string {
+	if format == "" {
+		format = p.Format
+	}
+	if formatOf(name, "") != "" {
+		if format == "" {
+			return name
+		}
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	if format == "" {
+		format = "pgm"
+	}
+	return name + "." + format
+}
+
+// outputPath returns where a file is written to in the output directory, or "-" for stdout when streaming.
+func outputPath(p Params, file string) string {
+	if p.Stream {
+		return "-"
+	}
+	dir := p.OutputDir
+	if dir == "" {
+		dir = "out"
+	}
+	return filepath.Join(dir, file)
 }
 
 // Run starts the processing of Game of Life. It should initialise channels and goroutines.
-func Run(p Params, events chan<- Event, keyPresses <-chan rune) {
+// It returns once the events channel is closed, with an error if the initial image couldn't be read
+// or the final image couldn't be written. Such errors are also sent as an IOError event.
+// When resuming from a checkpoint, every event counts turns from the start of the original run.
+func Run(p Params, events chan<- Event, keyPresses <-chan rune) error {
+	if p.Resume != "" {
+		resumed, err := resume(p)
+		if err != nil {
+			events <- IOError{CompletedTurns: 0, Err: err}
+			events <- StateChange{CompletedTurns: 0, NewState: Quitting}
+			close(events)
+			return err
+		}
+		p = resumed
+	}
 
 	//	TODO: Put the missing channels in here.
 
@@ -18,12 +193,18 @@ func Run(p Params, events chan<- Event, keyPresses <-chan rune) {
 	ioInput := make(chan uint8)
 	ioOutput := make(chan uint8)
 	ioFilename := make(chan string)
+	ioError := make(chan error)
+	ioSize := make(chan int)
+	ioTurn := make(chan int)
 	ioChannels := ioChannels{
 		command:  ioCommand,
 		idle:     ioIdle,
 		filename: ioFilename,
 		output:   ioOutput,
 		input:    ioInput,
+		err:      ioError,
+		size:     ioSize,
+		turn:     ioTurn,
 	}
 	go startIo(p, ioChannels)
 
@@ -34,7 +215,10 @@ func Run(p Params, events chan<- Event, keyPresses <-chan rune) {
 		ioFilename: ioFilename,
 		ioOutput:   ioOutput,
 		ioInput:    ioInput,
+		ioError:    ioError,
+		ioSize:     ioSize,
+		ioTurn:     ioTurn,
 		key:        keyPresses,
 	}
-	distributor(p, distributorChannels)
+	return distributor(p, distributorChannels)
 }
@@ -0,0 +1,67 @@
+package gol
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+)
+
+// heatSpan is the age in turns at which the heat palette reaches its coldest colour.
+const heatSpan = 64
+
+// sendsAges reports whether an image in the given format is followed by the age of every cell,
+// which only the heat palette of PNG images needs.
+func sendsAges(p Params, format string) bool {
+	return format == "png" && p.Palette == "heat"
+}
+
+// writePng writes a world as a PNG image with every cell drawn as a scale x scale square.
+// The classic palette draws cells as in a PGM image, with alive cells white, and inverted swaps black and white.
+// The heat palette draws alive cells from white when they are born through yellow and red to dark red,
+// using ages, the number of turns each cell has been alive for.
+func writePng(w io.Writer, world, ages [][]byte, palette string, scale int) error {
+	if scale < 1 {
+		scale = 1
+	}
+	height := len(world)
+	width := 0
+	if height > 0 {
+		width = len(world[0])
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, width*scale, height*scale))
+	for y, row := range world {
+		for x, cell := range row {
+			var c color.NRGBA
+			switch {
+			case palette == "inverted":
+				c = color.NRGBA{R: 255 - cell, G: 255 - cell, B: 255 - cell, A: 255}
+			case palette == "heat" && cell == 255:
+				c = heat(ages[y][x])
+			default:
+				c = color.NRGBA{R: cell, G: cell, B: cell, A: 255}
+			}
+			for dy := 0; dy < scale; dy++ {
+				for dx := 0; dx < scale; dx++ {
+					img.SetNRGBA(x*scale+dx, y*scale+dy, c)
+				}
+			}
+		}
+	}
+	return png.Encode(w, img)
+}
+
+// heat returns the colour of an alive cell of the given age.
+func heat(age byte) color.NRGBA {
+	t := float64(age) / heatSpan
+	if t > 1 {
+		t = 1
+	}
+	// blue fades first, then green, leaving red that darkens
+	return color.NRGBA{
+		R: uint8(255 - 119*t),
+		G: uint8(255 * (1 - t) * (1 - t)),
+		B: uint8(255 * (1 - t) * (1 - t) * (1 - t) * (1 - t)),
+		A: 255,
+	}
+}
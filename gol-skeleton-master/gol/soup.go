@@ -0,0 +1,39 @@
+package gol
+
+import (
+	"fmt"
+	"image"
+	"math/rand"
+)
+
+// randomSoup returns a world of the size in Params with every cell of Params.SoupArea alive with
+// chance Params.Soup. The same seed, density, area and size always give the same world.
+func randomSoup(p Params) ([][]byte, error) {
+	if p.ImageWidth == 0 || p.ImageHeight == 0 {
+		return nil, fmt.Errorf("%v: a soup needs the size of the world", soupName(p))
+	}
+	bounds := image.Rect(0, 0, p.ImageWidth, p.ImageHeight)
+	area := bounds
+	if !p.SoupArea.Empty() {
+		area = p.SoupArea.Intersect(bounds)
+	}
+
+	random := rand.New(rand.NewSource(p.Seed))
+	world := make([][]byte, p.ImageHeight)
+	for y := range world {
+		world[y] = make([]byte, p.ImageWidth)
+	}
+	for y := area.Min.Y; y < area.Max.Y; y++ {
+		for x := area.Min.X; x < area.Max.X; x++ {
+			if random.Float64() < p.Soup {
+				world[y][x] = 255
+			}
+		}
+	}
+	return world, nil
+}
+
+// soupName describes a soup, in place of the name of the file a world is read from.
+func soupName(p Params) string {
+	return fmt.Sprintf("soup %v seed %v", p.Soup, p.Seed)
+}
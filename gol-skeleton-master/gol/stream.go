@@ -0,0 +1,66 @@
+package gol
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"uk.ac.bris.cs/gameoflife/gol/engine"
+)
+
+// streamFormats lists the formats images can be streamed in with Params.Stream.
+var streamFormats = []string{"pgm", "rle", "cells"}
+
+// CheckStreamFormat returns an error if name is not a Params.Format that can be written to stdout.
+func CheckStreamFormat(name string) error {
+	for _, format := range streamFormats {
+		if name == "" || name == format {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid format %q for streaming: expected pgm, rle or cells", name)
+}
+
+// parseStream reads a pattern whose format isn't known from a filename, telling netpbm, RLE and
+// plaintext apart by their first line that isn't blank: netpbm starts with its magic number, an RLE
+// with its x = m, y = n header or # comments, and plaintext with ! comments or rows of cells.
+func parseStream(r io.Reader, rule engine.Rule) ([][]byte, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	parse := parseCells
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if len(line) >= 2 && line[0] == 'P' && line[1] >= '1' && line[1] <= '6' {
+			parse = parsePnm
+		} else if line[0] == '#' || line[0] == 'x' {
+			parse = parseRle
+		}
+		break
+	}
+	return parse(bytes.NewReader(data), rule)
+}
+
+// streamImage writes an array of bytes to w in the given format, which must be one of streamFormats.
+func (io *ioState) streamImage(w io.Writer, format string, world [][]byte) error {
+	switch format {
+	case "rle":
+		rule, err := engine.ParseRule(io.params.Rule)
+		if err != nil {
+			return err
+		}
+		return writeRle(w, world, rule)
+	case "cells":
+		return writeCells(w, world)
+	default:
+		return writePgm(w, world)
+	}
+}
@@ -3,13 +3,19 @@ package main
 import (
 	"flag"
 	"fmt"
+	"image"
 	"runtime"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"uk.ac.bris.cs/gameoflife/eventlog"
 	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/gol/engine"
+	"uk.ac.bris.cs/gameoflife/record"
 	"uk.ac.bris.cs/gameoflife/sdl"
+	"uk.ac.bris.cs/gameoflife/util"
 )
 
 // main is the function called when starting Game of Life with 'go run .'
@@ -26,14 +32,14 @@ func main() {
 	flag.IntVar(
 		&params.ImageWidth,
 		"w",
-		512,
-		"Specify the width of the image. Defaults to 512.")
+		0,
+		"Specify the width of the image. Defaults to 512, or the width of the -pattern file.")
 
 	flag.IntVar(
 		&params.ImageHeight,
 		"h",
-		512,
-		"Specify the height of the image. Defaults to 512.")
+		0,
+		"Specify the height of the image. Defaults to 512, or the height of the -pattern file.")
 
 	flag.IntVar(
 		&params.Turns,
@@ -41,6 +47,137 @@ func main() {
 		10000000000,
 		"Specify the number of turns to process. Defaults to 10000000000.")
 
+	flag.StringVar(
+		&params.Rule,
+		"rule",
+		engine.DefaultRule,
+		"Specify the birth/survival rulestring, e.g. B36/S23 or B2/S345/C4. Defaults to B3/S23.")
+
+	flag.StringVar(
+		&params.Topology,
+		"topology",
+		"torus",
+		"Specify how the edges of the world are joined: torus, plane, klein, cross, cylinder-x or cylinder-y. Defaults to torus.")
+
+	flag.StringVar(
+		&params.Engine,
+		"engine",
+		"default",
+		"Specify how turns are computed: default, naive, parallel, packed, hashlife or active. Packed and hashlife need a B/S rule, hashlife also a torus with power of two sides. Defaults to default.")
+
+	flag.StringVar(
+		&params.Pattern,
+		"pattern",
+		"",
+		"Specify an .rle, .cells or Life 1.06 .lif pattern file to start from instead of WxH.pgm in the input directory.")
+
+	offset := flag.String(
+		"offset",
+		"",
+		"Specify where the top left corner of the pattern, or the origin of a .lif file, goes as x,y. Defaults to centring it.")
+
+	flag.Float64Var(
+		&params.Soup,
+		"soup",
+		0,
+		"Specify the density of a random soup to start from instead of WxH.pgm, e.g. 0.35 for 35% of cells alive.")
+
+	flag.Int64Var(
+		&params.Seed,
+		"seed",
+		0,
+		"Specify the seed of the random soup, which is added to the names of images. Defaults to one picked from the clock.")
+
+	soupArea := flag.String(
+		"soup-area",
+		"",
+		"Specify the part of the world the soup fills as x,y,w,h. Defaults to all of it.")
+
+	flag.StringVar(
+		&params.Format,
+		"format",
+		"pgm",
+		"Specify the format of images written to the output directory: pgm, rle, cells, lif, png or golc, a checkpoint for -resume. Defaults to pgm.")
+
+	flag.StringVar(
+		&params.Palette,
+		"palette",
+		"classic",
+		"Specify the colours of png images: classic, inverted or heat, which colours alive cells by age. Defaults to classic.")
+
+	flag.IntVar(
+		&params.Scale,
+		"scale",
+		1,
+		"Specify the size in pixels of each cell of png images. Defaults to 1.")
+
+	flag.StringVar(
+		&params.InputDir,
+		"input",
+		"images",
+		"Specify the directory WxH.pgm is read from. Defaults to images.")
+
+	flag.StringVar(
+		&params.OutputDir,
+		"output",
+		"out",
+		"Specify the directory images are written to. Defaults to out.")
+
+	flag.StringVar(
+		&params.OutputName,
+		"name",
+		gol.DefaultOutputName,
+		"Specify the filename template of images written out, using {w}, {h}, {turn}, {ts}, {seed} and {pid}, e.g. {w}x{h}-t{turn}-{ts}.pgm. Defaults to {w}x{h}x{turn}.")
+
+	flag.BoolVar(
+		&params.Stream,
+		"stream",
+		false,
+		"Read the initial world from stdin and write the final world to stdout as pgm, rle or cells, with events as JSON lines on stderr. Implies -headless.")
+
+	flag.StringVar(
+		&params.Resume,
+		"resume",
+		"",
+		"Specify a .golc checkpoint to carry on from. Its size, rule, topology and turn are used instead of the flags.")
+
+	autosaveEvery := flag.String(
+		"autosave-every",
+		"",
+		"Specify how often to write a .golc checkpoint in the background, as a number of turns or a duration such as 30s.")
+
+	flag.IntVar(
+		&params.AutosaveKeep,
+		"autosave-keep",
+		gol.DefaultAutosaveKeep,
+		"Specify the number of the newest autosaves to keep. Defaults to 3.")
+
+	var recording record.Options
+
+	flag.StringVar(
+		&recording.Path,
+		"record",
+		"",
+		"Specify a .gif file to record an animation of the run to.")
+
+	flag.IntVar(
+		&recording.Every,
+		"record-every",
+		1,
+		"Specify the number of turns between recorded frames. Defaults to 1.")
+
+	flag.IntVar(
+		&recording.Scale,
+		"record-scale",
+		1,
+		"Specify the size in pixels of each recorded cell. Defaults to 1.")
+
+	flag.IntVar(
+		&recording.Frames,
+		"record-frames",
+		500,
+		"Specify the most frames to record, or 0 for no limit. Defaults to 500.")
+
 	headless := flag.Bool(
 		"headless",
 		false,
@@ -48,21 +185,174 @@ func main() {
 
 	flag.Parse()
 
-	fmt.Printf("%-10v %v\n", "Threads", params.Threads)
-	fmt.Printf("%-10v %v\n", "Width", params.ImageWidth)
-	fmt.Printf("%-10v %v\n", "Height", params.ImageHeight)
-	fmt.Printf("%-10v %v\n", "Turns", params.Turns)
+	if params.Resume != "" {
+		// the size comes from the checkpoint, and the window waits for it
+		params.ImageWidth, params.ImageHeight = 0, 0
+	}
+	if (params.ImageWidth == 0) != (params.ImageHeight == 0) {
+		fmt.Println("-w and -h must be given together")
+		os.Exit(1)
+	}
+	readsSize := params.Pattern != "" || params.Resume != "" || params.Stream && params.Soup == 0
+	if params.ImageWidth == 0 && !readsSize {
+		params.ImageWidth, params.ImageHeight = 512, 512
+	}
+	if _, err := engine.ParseRule(params.Rule); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if _, err := engine.ParseTopology(params.Topology); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err := engine.Check(params.Engine); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err := gol.CheckFormat(params.Format); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err := gol.CheckPalette(params.Palette); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if params.Scale < 1 {
+		fmt.Printf("invalid scale %d: expected at least 1\n", params.Scale)
+		os.Exit(1)
+	}
+	if err := gol.CheckOutputName(params.OutputName); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if *autosaveEvery != "" {
+		var err error
+		params.AutosaveTurns, params.AutosaveInterval, err = gol.ParseAutosave(*autosaveEvery)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	if params.AutosaveKeep < 1 {
+		fmt.Printf("invalid autosave keep %d: expected at least 1\n", params.AutosaveKeep)
+		os.Exit(1)
+	}
+	if recording.Path != "" {
+		if err := record.Check(recording); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	if params.Soup < 0 || params.Soup > 1 {
+		fmt.Printf("invalid soup density %v: expected between 0 and 1\n", params.Soup)
+		os.Exit(1)
+	}
+	if params.Soup > 0 && (params.Pattern != "" || params.Resume != "") {
+		fmt.Println("-soup can't be used with -pattern or -resume")
+		os.Exit(1)
+	}
+	if params.Soup > 0 && params.Seed == 0 {
+		// the seed is printed below, so the soup can be made again
+		params.Seed = time.Now().UnixNano()
+	}
+	if params.Stream {
+		if err := gol.CheckStreamFormat(params.Format); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		// stdout and stderr are taken by the world and the events
+		if params.Pattern != "" || params.Resume != "" || recording.Path != "" || *autosaveEvery != "" {
+			fmt.Println("-stream can't be used with -pattern, -resume, -record or -autosave-every")
+			os.Exit(1)
+		}
+	}
+	if *soupArea != "" {
+		var x, y, w, h int
+		if _, err := fmt.Sscanf(*soupArea, "%d,%d,%d,%d", &x, &y, &w, &h); err != nil || w < 1 || h < 1 {
+			fmt.Println("invalid soup area", *soupArea+": expected x,y,w,h")
+			os.Exit(1)
+		}
+		params.SoupArea = image.Rect(x, y, x+w, y+h)
+	}
+	if *offset != "" {
+		var cell util.Cell
+		if _, err := fmt.Sscanf(*offset, "%d,%d", &cell.X, &cell.Y); err != nil {
+			fmt.Println("invalid offset", *offset+": expected x,y")
+			os.Exit(1)
+		}
+		params.PatternOffset = &cell
+	}
+
+	if !params.Stream {
+		printParams(params, *autosaveEvery)
+	}
 
 	keyPresses := make(chan rune, 10)
 	events := make(chan gol.Event, 1000)
 
 	go sigterm(keyPresses)
 
-	go gol.Run(params, events, keyPresses)
-	if !(*headless) {
-		sdl.Run(params, events, keyPresses)
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- gol.Run(params, events, keyPresses)
+	}()
+	// The recorder sits between the run and the window, so it sees every event either way
+	var view <-chan gol.Event = events
+	recordErr := make(chan error, 1)
+	if recording.Path != "" {
+		recorded := make(chan gol.Event, 1000)
+		go func() {
+			recordErr <- record.Run(recording, events, recorded)
+		}()
+		view = recorded
+	} else {
+		recordErr <- nil
+	}
+	if params.Stream {
+		if err := eventlog.Run(view, os.Stderr); err != nil {
+			os.Exit(1)
+		}
+	} else if !(*headless) {
+		sdl.Run(params, view, keyPresses)
 	} else {
-		sdl.RunHeadless(events)
+		sdl.RunHeadless(view)
+	}
+	if err := <-recordErr; err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	// A failed read or write has already been reported as an IOError event, but it still sets the exit code
+	if err := <-runErr; err != nil {
+		if !params.Stream {
+			fmt.Println(err)
+		}
+		os.Exit(1)
+	}
+}
+
+// printParams prints the parameters of the run, before it starts.
+func printParams(params gol.Params, autosaveEvery string) {
+	fmt.Printf("%-10v %v\n", "Threads", params.Threads)
+	if params.ImageWidth != 0 {
+		fmt.Printf("%-10v %v\n", "Width", params.ImageWidth)
+		fmt.Printf("%-10v %v\n", "Height", params.ImageHeight)
+	}
+	fmt.Printf("%-10v %v\n", "Turns", params.Turns)
+	fmt.Printf("%-10v %v\n", "Rule", params.Rule)
+	fmt.Printf("%-10v %v\n", "Topology", params.Topology)
+	fmt.Printf("%-10v %v\n", "Engine", params.Engine)
+	if params.Pattern != "" {
+		fmt.Printf("%-10v %v\n", "Pattern", params.Pattern)
+	}
+	if params.Resume != "" {
+		fmt.Printf("%-10v %v\n", "Resume", params.Resume)
+	}
+	if params.Soup > 0 {
+		fmt.Printf("%-10v %v\n", "Soup", params.Soup)
+		fmt.Printf("%-10v %v\n", "Seed", params.Seed)
+	}
+	if autosaveEvery != "" {
+		fmt.Printf("%-10v %v\n", "Autosave", autosaveEvery)
 	}
 }
 
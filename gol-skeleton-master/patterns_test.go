@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestPatterns tests that .cells and Life 1.06 patterns are placed like RLE ones, and that worlds written
+// in either format read back the same.
+func TestPatterns(t *testing.T) {
+	glider := []util.Cell{{X: 1, Y: 0}, {X: 2, Y: 1}, {X: 0, Y: 2}, {X: 1, Y: 2}, {X: 2, Y: 2}}
+	shifted := func(dx, dy int) []util.Cell {
+		var cells []util.Cell
+		for _, cell := range glider {
+			cells = append(cells, util.Cell{X: cell.X + dx, Y: cell.Y + dy})
+		}
+		return cells
+	}
+	tests := []struct {
+		pattern  string
+		offset   *util.Cell
+		expected []util.Cell
+	}{
+		{"images/glider.cells", nil, shifted(6, 6)},
+		{"images/glider.cells", &util.Cell{X: 3, Y: 9}, shifted(3, 9)},
+		// the origin of the Life 1.06 glider is its middle cell
+		{"images/glider.lif", nil, shifted(7, 7)},
+		{"images/glider.lif", &util.Cell{X: 1, Y: 1}, shifted(0, 0)},
+	}
+	for _, test := range tests {
+		p := gol.Params{ImageWidth: 16, ImageHeight: 16, Turns: 0, Threads: 1, Pattern: test.pattern, PatternOffset: test.offset}
+		t.Run(fmt.Sprintf("%v-%v", test.pattern, test.offset), func(t *testing.T) {
+			assertEqualBoard(t, runFinal(p), test.expected, p)
+		})
+	}
+
+	for _, format := range []string{"cells", "lif"} {
+		for _, size := range []int{16, 64} {
+			p := gol.Params{ImageWidth: size, ImageHeight: size, Turns: 100, Threads: 1, Format: format}
+			runFinal(p)
+			p = gol.Params{ImageWidth: size, ImageHeight: size, Turns: 0, Threads: 1,
+				Pattern: fmt.Sprintf("out/%vx%vx100.%v", size, size, format), PatternOffset: &util.Cell{}}
+			expectedAlive := readAliveCells(fmt.Sprintf("check/images/%vx%vx100.pgm", size, size), size, size)
+			t.Run(fmt.Sprintf("round-trip-%v-%dx%d", format, size, size), func(t *testing.T) {
+				assertEqualBoard(t, runFinal(p), expectedAlive, p)
+			})
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestRle tests that RLE patterns are placed centred or at an offset, and that worlds written as RLE read back the same.
+func TestRle(t *testing.T) {
+	glider := []util.Cell{{X: 1, Y: 0}, {X: 2, Y: 1}, {X: 0, Y: 2}, {X: 1, Y: 2}, {X: 2, Y: 2}}
+	shifted := func(dx, dy int) []util.Cell {
+		var cells []util.Cell
+		for _, cell := range glider {
+			cells = append(cells, util.Cell{X: (cell.X + dx) % 16, Y: (cell.Y + dy) % 16})
+		}
+		return cells
+	}
+	tests := []struct {
+		offset   *util.Cell
+		turns    int
+		expected []util.Cell
+	}{
+		{nil, 0, shifted(6, 6)},
+		{&util.Cell{X: 0, Y: 0}, 0, shifted(0, 0)},
+		{&util.Cell{X: 13, Y: 2}, 4, shifted(14, 3)},
+		{&util.Cell{X: 0, Y: 0}, 64, shifted(0, 0)},
+	}
+	for _, test := range tests {
+		p := gol.Params{ImageWidth: 16, ImageHeight: 16, Turns: test.turns, Threads: 1, Pattern: "images/glider.rle", PatternOffset: test.offset}
+		t.Run(fmt.Sprintf("glider-%v-%d", test.offset, test.turns), func(t *testing.T) {
+			assertEqualBoard(t, runFinal(p), test.expected, p)
+		})
+	}
+
+	for _, size := range []int{16, 64} {
+		p := gol.Params{ImageWidth: size, ImageHeight: size, Turns: 100, Threads: 1, Format: "rle"}
+		runFinal(p)
+		p = gol.Params{ImageWidth: size, ImageHeight: size, Turns: 0, Threads: 1,
+			Pattern: fmt.Sprintf("out/%vx%vx100.rle", size, size), PatternOffset: &util.Cell{}}
+		expectedAlive := readAliveCells(fmt.Sprintf("check/images/%vx%vx100.pgm", size, size), size, size)
+		t.Run(fmt.Sprintf("round-trip-%dx%d", size, size), func(t *testing.T) {
+			assertEqualBoard(t, runFinal(p), expectedAlive, p)
+		})
+	}
+
+	// dying states of a Generations rule survive the round trip as multi-state cells
+	p := gol.Params{ImageWidth: 64, ImageHeight: 64, Turns: 10, Threads: 1, Rule: "B2/S345/C4"}
+	runFinal(p)
+	p.Format = "rle"
+	runFinal(p)
+	p = gol.Params{ImageWidth: 64, ImageHeight: 64, Turns: 0, Threads: 1, Rule: "B2/S345/C4",
+		Pattern: "out/64x64x10.rle", PatternOffset: &util.Cell{}}
+	runFinal(p)
+	expected, err := os.ReadFile("out/64x64x10.pgm")
+	util.Check(err)
+	given, err := os.ReadFile("out/64x64x0.pgm")
+	util.Check(err)
+	if !bytes.Equal(given, expected) {
+		t.Errorf("ERROR: B2/S345/C4 world read back from RLE differs from the original")
+	}
+}
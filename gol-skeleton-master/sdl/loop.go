@@ -11,6 +11,14 @@ import (
 const FPS = 60
 
 func Run(p gol.Params, events <-chan gol.Event, keyPresses chan<- rune) {
+	if p.ImageWidth == 0 || p.ImageHeight == 0 {
+		// The size is read from the input file, so the window can't be opened until it is known
+		size, ok := waitForSize(events)
+		if !ok {
+			return
+		}
+		p.ImageWidth, p.ImageHeight = size.Width, size.Height
+	}
 	w := NewWindow(int32(p.ImageWidth), int32(p.ImageHeight))
 	defer w.Destroy()
 	dirty := false
@@ -34,6 +42,8 @@ sdl:
 						keyPresses <- 'p'
 					case sdl.K_s:
 						keyPresses <- 's'
+					case sdl.K_n:
+						keyPresses <- 'n'
 					case sdl.K_q:
 						keyPresses <- 'q'
 					case sdl.K_k:
@@ -57,6 +67,10 @@ sdl:
 				for _, cell := range e.Cells {
 					w.FlipPixel(cell.X, cell.Y) 
 				}
+			case gol.CellsUpdated:
+				for i, cell := range e.Cells {
+					w.SetShade(cell.X, cell.Y, e.States[i])
+				}
 			case gol.TurnComplete:
 				dirty = true
 			case gol.AliveCellsCount:
@@ -65,6 +79,8 @@ sdl:
 				fmt.Printf("Completed Turns %-8v %v\n", event.GetCompletedTurns(), event)
 			case gol.ImageOutputComplete:
 				fmt.Printf("Completed Turns %-8v %v\n", event.GetCompletedTurns(), event)
+			case gol.IOError:
+				fmt.Printf("Completed Turns %-8v %v\n", event.GetCompletedTurns(), event)
 			case gol.StateChange:
 				fmt.Printf("Completed Turns %-8v %v\n", event.GetCompletedTurns(), event)
 				if e.NewState == gol.Quitting {
@@ -75,6 +91,20 @@ sdl:
 	}
 }
 
+// waitForSize returns the WorldSize event, printing anything sent before it.
+// It returns false if the events channel is closed first, as it is when the input can't be read.
+func waitForSize(events <-chan gol.Event) (gol.WorldSize, bool) {
+	for event := range events {
+		switch e := event.(type) {
+		case gol.WorldSize:
+			return e, true
+		case gol.IOError, gol.StateChange:
+			fmt.Printf("Completed Turns %-8v %v\n", event.GetCompletedTurns(), event)
+		}
+	}
+	return gol.WorldSize{}, false
+}
+
 func RunHeadless(events <-chan gol.Event) {
 	avgTurns := util.NewAvgTurns()
 	for event := range events {
@@ -85,6 +115,8 @@ func RunHeadless(events <-chan gol.Event) {
 			fmt.Printf("Completed Turns %-8v %v\n", event.GetCompletedTurns(), "Final Turn Complete")
 		case gol.ImageOutputComplete:
 			fmt.Printf("Completed Turns %-8v %v\n", event.GetCompletedTurns(), event)
+		case gol.IOError:
+			fmt.Printf("Completed Turns %-8v %v\n", event.GetCompletedTurns(), event)
 		case gol.StateChange:
 			fmt.Printf("Completed Turns %-8v %v\n", event.GetCompletedTurns(), event)
 			if e.NewState == gol.Quitting {
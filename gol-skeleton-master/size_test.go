@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/gol/engine"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestWorldSize tests that a world of size 0 takes its size from the input file, that the size is
+// published in a WorldSize event before any cells, and that non-square worlds step correctly.
+func TestWorldSize(t *testing.T) {
+	const width, height = 96, 24
+	world := make([][]byte, height)
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "P5\n%d %d\n255\n", width, height)
+	random := rand.New(rand.NewSource(1))
+	for y := range world {
+		world[y] = make([]byte, width)
+		for x := range world[y] {
+			if random.Intn(3) == 0 {
+				world[y][x] = 255
+			}
+		}
+		b.Write(world[y])
+	}
+	input := filepath.Join(t.TempDir(), "wide.pgm")
+	if err := os.WriteFile(input, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rule, _ := engine.ParseRule("")
+	topology, _ := engine.ParseTopology("")
+	naive, _ := engine.New("naive", rule, topology, 1)
+	for _, name := range []string{"naive", "parallel", "packed", "active"} {
+		for _, turns := range []int{0, 1, 50} {
+			p := gol.Params{Turns: turns, Threads: 4, Engine: name, Pattern: input, OutputDir: t.TempDir()}
+			t.Run(fmt.Sprintf("%v-%d", name, turns), func(t *testing.T) {
+				size, alive := runSize(t, p)
+				if size.Width != width || size.Height != height {
+					t.Fatalf("ERROR: expected a %dx%d world, got %v", width, height, size)
+				}
+				expected := naive.Alive(naive.StepN(world, turns))
+				assertEqualBoard(t, alive, expected, gol.Params{ImageWidth: width, ImageHeight: height, Turns: turns})
+				written := readAliveCells(filepath.Join(p.OutputDir, fmt.Sprintf("%dx%dx%d.pgm", width, height, turns)), width, height)
+				assertEqualBoard(t, written, expected, gol.Params{ImageWidth: width, ImageHeight: height, Turns: turns})
+			})
+		}
+	}
+
+	// patterns are given the smallest world that holds them, and a .lif file its bounding box
+	glider := []util.Cell{{X: 1, Y: 0}, {X: 2, Y: 1}, {X: 0, Y: 2}, {X: 1, Y: 2}, {X: 2, Y: 2}}
+	tests := []struct {
+		pattern  string
+		offset   *util.Cell
+		width    int
+		height   int
+		expected []util.Cell
+	}{
+		{"images/glider.rle", nil, 3, 3, glider},
+		{"images/glider.cells", &util.Cell{X: 2, Y: 1}, 5, 4, nil},
+		{"images/glider.lif", nil, 3, 3, glider},
+	}
+	for _, test := range tests {
+		p := gol.Params{Turns: 0, Threads: 1, Pattern: test.pattern, PatternOffset: test.offset, OutputDir: t.TempDir()}
+		t.Run(test.pattern, func(t *testing.T) {
+			size, alive := runSize(t, p)
+			if size.Width != test.width || size.Height != test.height {
+				t.Fatalf("ERROR: expected a %dx%d world, got %v", test.width, test.height, size)
+			}
+			expected := test.expected
+			if expected == nil {
+				for _, cell := range glider {
+					expected = append(expected, util.Cell{X: cell.X + test.offset.X, Y: cell.Y + test.offset.Y})
+				}
+			}
+			assertEqualBoard(t, alive, expected, gol.Params{ImageWidth: size.Width, ImageHeight: size.Height})
+		})
+	}
+}
+
+// runSize runs the Game of Life and returns its WorldSize event and final alive cells,
+// failing if any cells are sent before the size.
+func runSize(t *testing.T, p gol.Params) (gol.WorldSize, []util.Cell) {
+	events := make(chan gol.Event)
+	go gol.Run(p, events, nil)
+	var size gol.WorldSize
+	var alive []util.Cell
+	for event := range events {
+		switch e := event.(type) {
+		case gol.WorldSize:
+			size = e
+		case gol.CellFlipped, gol.CellsFlipped, gol.CellsUpdated:
+			if size.Width == 0 {
+				t.Errorf("ERROR: %T sent before WorldSize", e)
+			}
+		case gol.FinalTurnComplete:
+			alive = e.Alive
+		}
+	}
+	return size, alive
+}
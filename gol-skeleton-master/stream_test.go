@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/eventlog"
+	"uk.ac.bris.cs/gameoflife/gol"
+)
+
+// TestStream tests that a streamed run reads its world from stdin in any of the streamed formats,
+// writes the final world to stdout and that its events can be written as JSON lines.
+func TestStream(t *testing.T) {
+	t.Run("pgm", func(t *testing.T) {
+		input, err := os.Open("images/16x16.pgm")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer input.Close()
+		output := filepath.Join(t.TempDir(), "16x16x100.pgm")
+		p := gol.Params{Turns: 100, Threads: 1, Stream: true}
+		log := runStream(t, p, input, output)
+
+		expectedAlive := readAliveCells("check/images/16x16x100.pgm", 16, 16)
+		assertEqualBoard(t, readAliveCells(output, 16, 16), expectedAlive, gol.Params{ImageWidth: 16, ImageHeight: 16, Turns: 100})
+
+		var types []string
+		scanner := bufio.NewScanner(bytes.NewReader(log))
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			var event map[string]interface{}
+			if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+				t.Fatalf("ERROR: expected a JSON object on every line, got %q: %v", scanner.Text(), err)
+			}
+			types = append(types, event["type"].(string))
+			if event["type"] == "StateChange" && event["NewState"] != "Executing" && event["NewState"] != "Quitting" {
+				t.Errorf("ERROR: expected the state by name, got %v", event["NewState"])
+			}
+			if event["type"] == "ImageOutputComplete" && event["Path"] != "-" {
+				t.Errorf("ERROR: expected the final image to be written to stdout, got %v", event["Path"])
+			}
+		}
+		if len(types) < 3 || types[0] != "WorldSize" || types[len(types)-1] != "StateChange" {
+			t.Errorf("ERROR: expected events from WorldSize to the final StateChange, got %v", types)
+		}
+	})
+
+	for _, test := range []struct {
+		format string
+		input  string
+		output string
+	}{
+		{"rle", "#N Blinker\nx = 3, y = 3\n$3o!\n", "x = 3, y = 3, rule = B3/S23\nbo$bo$bo!\n"},
+		{"cells", "!Name: Blinker\n...\nOOO\n...\n", ".O.\n.O.\n.O.\n"},
+	} {
+		t.Run(test.format, func(t *testing.T) {
+			input := filepath.Join(t.TempDir(), "input")
+			if err := os.WriteFile(input, []byte(test.input), 0644); err != nil {
+				t.Fatal(err)
+			}
+			file, err := os.Open(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+			output := filepath.Join(t.TempDir(), "output")
+			p := gol.Params{Turns: 1, Threads: 1, Topology: "plane", Format: test.format, Stream: true}
+			runStream(t, p, file, output)
+			written, err := os.ReadFile(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.TrimSpace(string(written)) != strings.TrimSpace(test.output) {
+				t.Errorf("ERROR: expected %q on stdout, got %q", test.output, written)
+			}
+		})
+	}
+}
+
+// runStream runs the Game of Life with stdin read from input and stdout written to output,
+// and returns the events written as JSON lines.
+func runStream(t *testing.T, p gol.Params, input *os.File, output string) []byte {
+	stdout, err := os.Create(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdout.Close()
+	realStdin, realStdout := os.Stdin, os.Stdout
+	os.Stdin, os.Stdout = input, stdout
+	defer func() {
+		os.Stdin, os.Stdout = realStdin, realStdout
+	}()
+
+	events := make(chan gol.Event)
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- gol.Run(p, events, nil)
+	}()
+	var log bytes.Buffer
+	if err := eventlog.Run(events, &log); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-runErr; err != nil {
+		t.Fatal(err)
+	}
+	return log.Bytes()
+}
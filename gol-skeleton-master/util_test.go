@@ -559,3 +559,17 @@ func (l *LimitedAssert) LimitHitMessage(msg string) {
 		l.t.Log(msg)
 	}
 }
+
+// runFinal runs p to completion, answering the alive cells of the final turn.
+func runFinal(p gol.Params) []util.Cell {
+	events := make(chan gol.Event)
+	go gol.Run(p, events, nil)
+	var cells []util.Cell
+	for event := range events {
+		switch e := event.(type) {
+		case gol.FinalTurnComplete:
+			cells = e.Alive
+		}
+	}
+	return cells
+}